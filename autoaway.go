@@ -0,0 +1,97 @@
+package steam
+
+import (
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"sync"
+	"time"
+)
+
+// How often the auto-away timer checks for inactivity
+const autoAwayCheckInterval = time.Second
+
+// autoAway switches the persona state to Away and then Snooze after periods of inactivity,
+// and restores the previous state on activity
+type autoAway struct {
+	social      *Social
+	awayAfter   time.Duration
+	snoozeAfter time.Duration
+	now         func() time.Time
+
+	mutex        sync.Mutex
+	lastActivity time.Time
+	state        EPersonaState // the state set by auto-away, Offline while not away
+	restore      EPersonaState // the state to restore on activity
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAutoAway(social *Social, awayAfter, snoozeAfter time.Duration, now func() time.Time) *autoAway {
+	return &autoAway{
+		social:       social,
+		awayAfter:    awayAfter,
+		snoozeAfter:  snoozeAfter,
+		now:          now,
+		lastActivity: now(),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (a *autoAway) run(interval time.Duration) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.tick()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// close stops the timer and waits for it to exit
+func (a *autoAway) close() {
+	close(a.stop)
+	<-a.done
+}
+
+func (a *autoAway) notifyActivity() {
+	a.mutex.Lock()
+	a.lastActivity = a.now()
+	a.mutex.Unlock()
+	a.tick()
+}
+
+// tick sets the persona state matching the time since the last activity
+func (a *autoAway) tick() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	idle := a.now().Sub(a.lastActivity)
+	target := EPersonaState_Offline
+	if a.snoozeAfter > 0 && idle >= a.snoozeAfter {
+		target = EPersonaState_Snooze
+	} else if a.awayAfter > 0 && idle >= a.awayAfter {
+		target = EPersonaState_Away
+	}
+	if target == a.state {
+		return
+	}
+	if a.state == EPersonaState_Offline {
+		// don't reveal a user who appears offline
+		if current := a.social.GetPersonaState(); current == EPersonaState_Offline || current == EPersonaState_Invisible {
+			return
+		}
+	}
+	if target == EPersonaState_Offline {
+		a.social.SetPersonaState(a.restore)
+	} else {
+		if a.state == EPersonaState_Offline {
+			a.restore = a.social.GetPersonaState()
+		}
+		a.social.SetPersonaState(target)
+	}
+	a.state = target
+}
@@ -0,0 +1,58 @@
+package steam
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The number of avatar images kept in memory by FetchAvatar
+const DefaultAvatarCacheSize = 64
+
+// avatarCache keeps the most recently used avatar images by hash
+type avatarCache struct {
+	mutex  sync.Mutex
+	size   int
+	order  *list.List // of *avatarEntry, most recently used first
+	byHash map[string]*list.Element
+}
+
+type avatarEntry struct {
+	hash string
+	data []byte
+}
+
+func newAvatarCache(size int) *avatarCache {
+	return &avatarCache{
+		size:   size,
+		order:  list.New(),
+		byHash: make(map[string]*list.Element),
+	}
+}
+
+func (c *avatarCache) get(hash string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.byHash[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return append([]byte(nil), elem.Value.(*avatarEntry).data...), true
+}
+
+func (c *avatarCache) add(hash string, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	data = append([]byte(nil), data...)
+	if elem, ok := c.byHash[hash]; ok {
+		elem.Value.(*avatarEntry).data = data
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.byHash[hash] = c.order.PushFront(&avatarEntry{hash, data})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.byHash, oldest.Value.(*avatarEntry).hash)
+	}
+}
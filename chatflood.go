@@ -0,0 +1,52 @@
+package steam
+
+import (
+	"github.com/anovokreschenov/go-steam/steamid"
+	"sync"
+	"time"
+)
+
+// chatFlood counts the messages of every chat member per room over a sliding window
+type chatFlood struct {
+	threshold int
+	window    time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	messages map[chatFloodKey][]time.Time
+}
+
+type chatFloodKey struct {
+	room, chatter steamid.SteamId
+}
+
+func newChatFlood(threshold int, window time.Duration, now func() time.Time) *chatFlood {
+	return &chatFlood{
+		threshold: threshold,
+		window:    window,
+		now:       now,
+		messages:  make(map[chatFloodKey][]time.Time),
+	}
+}
+
+// add records a message and returns the number of messages of the chatter in the window.
+// flooding is true only for the message that exceeds the threshold
+func (f *chatFlood) add(room, chatter steamid.SteamId) (count int, flooding bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := f.now()
+	cutoff := now.Add(-f.window)
+	for key, times := range f.messages {
+		if !times[len(times)-1].After(cutoff) {
+			delete(f.messages, key)
+		}
+	}
+	key := chatFloodKey{room, chatter}
+	times := f.messages[key]
+	for len(times) > 0 && !times[0].After(cutoff) {
+		times = times[1:]
+	}
+	times = append(times, now)
+	f.messages[key] = times
+	return len(times), len(times) == f.threshold+1
+}
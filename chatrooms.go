@@ -0,0 +1,358 @@
+package steam
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/anovokreschenov/go-steam/protocol"
+	. "github.com/anovokreschenov/go-steam/protocol/protobuf"
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/socialcache"
+	"github.com/anovokreschenov/go-steam/steamid"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sort"
+	"time"
+)
+
+// chatRoomChannel addresses a channel of a chat room group in the new chat system,
+// which uses numeric ids instead of SteamIds
+type chatRoomChannel struct {
+	groupId uint64
+	chatId  uint64
+}
+
+// UseNewChatRooms sets whether messages to chat rooms are sent through the ChatRoom service of
+// the new chat system. Rooms that aren't known to have a channel there, and messages that aren't
+// plain chat messages, are still sent as legacy chat messages. Channels are known from incoming
+// messages, RequestChatRoomGroups or SetChatRoomChannel
+func (s *Social) UseNewChatRooms(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.newChatRooms = enabled
+}
+
+// chatRoomSteamId returns the SteamId that represents the chat room group of the given channel
+// in events. Groups that no SteamId was associated with are given one, with the channel as the
+// default channel messages to the group are sent to
+func (s *Social) chatRoomSteamId(channel chatRoomChannel) steamid.SteamId {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, c := range s.chatRoomChannels {
+		if c.groupId == channel.groupId {
+			return id
+		}
+	}
+	id := newChatRoomGroupSteamId(channel.groupId)
+	s.chatRoomChannels[id] = channel
+	return id
+}
+
+// The account instance of the chat SteamIds given to chat room groups without a clan.
+// Legacy chat rooms have an instance of zero or the clan flag, so their ids can't collide
+const chatRoomGroupInstance = uint32(steamid.ChatInstanceFlagMMSLobby) >> 1
+
+// newChatRoomGroupSteamId returns the chat SteamId given to a chat room group that doesn't belong to a clan
+func newChatRoomGroupSteamId(groupId uint64) steamid.SteamId {
+	return steamid.NewIdAdv(uint32(groupId), chatRoomGroupInstance, int32(EUniverse_Public), EAccountType_Chat)
+}
+
+// registerChatRoomGroup associates a chat room group with the chat of its clan, or with the SteamId
+// it was given before if there is no clan, and returns that SteamId. Messages to the group are sent to
+// its default channel unless the SteamId was already associated with the group
+func (s *Social) registerChatRoomGroup(clan steamid.SteamId, channel chatRoomChannel) steamid.SteamId {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := newChatRoomGroupSteamId(channel.groupId)
+	if clan != 0 {
+		id = clan.ClanToChat()
+	}
+	for key, c := range s.chatRoomChannels {
+		if c.groupId != channel.groupId {
+			continue
+		}
+		if clan == 0 || key == id {
+			return key
+		}
+		delete(s.chatRoomChannels, key)
+	}
+	s.chatRoomChannels[id] = channel
+	return id
+}
+
+// unregisterChatRoomGroup forgets the channels of a chat room group, so messages to it are no
+// longer sent through the new chat system
+func (s *Social) unregisterChatRoomGroup(groupId uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, c := range s.chatRoomChannels {
+		if c.groupId == groupId {
+			delete(s.chatRoomChannels, key)
+		}
+	}
+}
+
+// SetChatRoomChannel routes messages to a chat room through a channel of a chat room group in the
+// new chat system, see UseNewChatRooms. The channel becomes the default channel of the group and
+// messages from any of its channels are reported with the given room as ChatRoomId
+func (s *Social) SetChatRoomChannel(room steamid.SteamId, groupId, chatId uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, c := range s.chatRoomChannels {
+		if c.groupId == groupId {
+			delete(s.chatRoomChannels, key)
+		}
+	}
+	s.chatRoomChannels[room.ClanToChat()] = chatRoomChannel{groupId: groupId, chatId: chatId}
+}
+
+// chatRoomChannel returns the channel to send messages to the given room through,
+// or false if the new chat system isn't used for it
+func (s *Social) chatRoomChannel(room steamid.SteamId) (chatRoomChannel, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if !s.newChatRooms {
+		return chatRoomChannel{}, false
+	}
+	channel, ok := s.chatRoomChannels[room.ClanToChat()]
+	return channel, ok
+}
+
+// The ChatRoom.SendChatMessage request isn't part of the generated protobufs, so its body
+// (chat_group_id = 1, chat_id = 2, message = 3) is encoded by hand
+func newChatRoomMsg(channel chatRoomChannel, message string) IClientMsg {
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.VarintType)
+	body = protowire.AppendVarint(body, channel.groupId)
+	body = protowire.AppendTag(body, 2, protowire.VarintType)
+	body = protowire.AppendVarint(body, channel.chatId)
+	body = protowire.AppendTag(body, 3, protowire.BytesType)
+	body = protowire.AppendString(body, message)
+	return NewClientMsgProtobuf(EMsg_ClientServiceMethod, &CMsgClientServiceMethod{
+		MethodName:       proto.String("ChatRoom.SendChatMessage#1"),
+		SerializedMethod: body,
+	})
+}
+
+// The methods and notifications of the new chat system
+const (
+	chatRoomIncomingMessageMethod = "ChatRoomClient.NotifyIncomingChatMessage#1"
+	chatRoomGetGroupsMethod       = "ChatRoom.GetMyChatRoomGroups#1"
+)
+
+// consumeFields calls fn for every field of a protobuf message with the field's number, type and
+// the data following its tag. fn returns the length of the value it consumed, or zero to skip it
+func consumeFields(raw []byte, fn func(num protowire.Number, typ protowire.Type, raw []byte) int) error {
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		raw = raw[n:]
+		n = fn(num, typ, raw)
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, raw)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		raw = raw[n:]
+	}
+	return nil
+}
+
+// consumeEmbedded returns the values of all embedded message fields with the given number
+func consumeEmbedded(raw []byte, field protowire.Number) ([][]byte, error) {
+	var messages [][]byte
+	err := consumeFields(raw, func(num protowire.Number, typ protowire.Type, raw []byte) (n int) {
+		if num == field && typ == protowire.BytesType {
+			var message []byte
+			message, n = protowire.ConsumeBytes(raw)
+			messages = append(messages, message)
+		}
+		return n
+	})
+	return messages, err
+}
+
+// RequestChatRoomGroups requests the chat room groups of the new chat system we are in.
+// A ChatRoomGroupsEvent is emitted once they have been received and cached in ChatRoomGroups
+func (s *Social) RequestChatRoomGroups() {
+	s.client.Write(NewClientMsgProtobuf(EMsg_ClientServiceMethod, &CMsgClientServiceMethod{
+		MethodName: proto.String(chatRoomGetGroupsMethod),
+	}))
+}
+
+// GetChatRoomGroups returns copies of the cached chat room groups, see RequestChatRoomGroups
+func (s *Social) GetChatRoomGroups() []socialcache.ChatRoomGroup {
+	groups := make([]socialcache.ChatRoomGroup, 0, s.ChatRoomGroups.Count())
+	for _, group := range s.ChatRoomGroups.GetCopy() {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].GroupId < groups[j].GroupId
+	})
+	return groups
+}
+
+// handleServiceMethod dispatches service method notifications by their method name
+func (s *Social) handleServiceMethod(packet *Packet) {
+	header := NewMsgHdrProtoBuf()
+	buf := bytes.NewBuffer(packet.Data)
+	if err := header.Deserialize(buf); err != nil {
+		s.emitParseError(packet, err)
+		return
+	}
+	switch header.Proto.GetTargetJobName() {
+	case chatRoomIncomingMessageMethod:
+		s.handleIncomingChatRoomMessage(packet, buf.Bytes())
+	}
+}
+
+// handleServiceMethodResponse dispatches the responses to service methods by their method name
+func (s *Social) handleServiceMethodResponse(packet *Packet) {
+	body := new(CMsgClientServiceMethodResponse)
+	packet.ReadProtoMsg(body)
+	switch body.GetMethodName() {
+	case chatRoomGetGroupsMethod:
+		s.handleChatRoomGroups(packet, body.GetSerializedMethodResponse())
+	}
+}
+
+// The ChatRoom.GetMyChatRoomGroups response isn't part of the generated protobufs, so it is decoded
+// by hand. It holds summary pairs (chat_room_groups = 1) with a summary each (group_summary = 2)
+func (s *Social) handleChatRoomGroups(packet *Packet, raw []byte) {
+	var groups []socialcache.ChatRoomGroup
+	pairs, err := consumeEmbedded(raw, 1)
+	for _, pair := range pairs {
+		var summaries [][]byte
+		if summaries, err = consumeEmbedded(pair, 2); err != nil {
+			break
+		}
+		for _, summary := range summaries {
+			var group socialcache.ChatRoomGroup
+			if group, err = readChatRoomGroupSummary(summary); err != nil {
+				break
+			}
+			groups = append(groups, group)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		s.emitParseError(packet, err)
+		return
+	}
+	present := make(map[steamid.SteamId]bool, len(groups))
+	for i, group := range groups {
+		channel := chatRoomChannel{groupId: group.GroupId, chatId: group.DefaultChatId}
+		groups[i].SteamId = s.registerChatRoomGroup(group.ClanId, channel)
+		s.ChatRoomGroups.Add(groups[i])
+		present[groups[i].SteamId] = true
+	}
+	// the response lists all groups the user is in, so cached groups missing from it were left
+	for id, group := range s.ChatRoomGroups.GetCopy() {
+		if !present[id] {
+			s.ChatRoomGroups.Remove(id)
+			s.unregisterChatRoomGroup(group.GroupId)
+		}
+	}
+	s.emit(&ChatRoomGroupsEvent{Groups: groups})
+}
+
+// readChatRoomGroupSummary decodes a chat room group summary (chat_group_id = 1, chat_group_name = 2,
+// default_chat_id = 5, chat_rooms = 6, clanid = 7, chat_group_tagline = 8) with its channels
+// (chat_id = 1, chat_name = 2)
+func readChatRoomGroupSummary(raw []byte) (socialcache.ChatRoomGroup, error) {
+	var group socialcache.ChatRoomGroup
+	var clanId uint64
+	err := consumeFields(raw, func(num protowire.Number, typ protowire.Type, raw []byte) (n int) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			group.GroupId, n = protowire.ConsumeVarint(raw)
+		case num == 2 && typ == protowire.BytesType:
+			group.Name, n = protowire.ConsumeString(raw)
+		case num == 5 && typ == protowire.VarintType:
+			group.DefaultChatId, n = protowire.ConsumeVarint(raw)
+		case num == 7 && typ == protowire.VarintType:
+			clanId, n = protowire.ConsumeVarint(raw)
+		case num == 8 && typ == protowire.BytesType:
+			group.Tagline, n = protowire.ConsumeString(raw)
+		}
+		return n
+	})
+	if err != nil {
+		return group, err
+	}
+	if group.GroupId == 0 {
+		return group, errors.New("Chat room group without a chat group id")
+	}
+	if clanId != 0 {
+		group.ClanId = steamid.NewClan(uint32(clanId))
+	}
+	states, err := consumeEmbedded(raw, 6)
+	for _, state := range states {
+		var channel socialcache.ChatRoomChannel
+		err = consumeFields(state, func(num protowire.Number, typ protowire.Type, raw []byte) (n int) {
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				channel.ChatId, n = protowire.ConsumeVarint(raw)
+			case num == 2 && typ == protowire.BytesType:
+				channel.Name, n = protowire.ConsumeString(raw)
+			}
+			return n
+		})
+		if err != nil {
+			break
+		}
+		group.Channels = append(group.Channels, channel)
+	}
+	return group, err
+}
+
+// The ChatRoomClient.NotifyIncomingChatMessage notification isn't part of the generated protobufs,
+// so its body (chat_group_id = 1, chat_id = 2, steamid_sender = 3, message = 4, timestamp = 5)
+// is decoded by hand
+func (s *Social) handleIncomingChatRoomMessage(packet *Packet, raw []byte) {
+	var channel chatRoomChannel
+	var sender, timestamp uint64
+	var message string
+	err := consumeFields(raw, func(num protowire.Number, typ protowire.Type, raw []byte) (n int) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			channel.groupId, n = protowire.ConsumeVarint(raw)
+		case num == 2 && typ == protowire.VarintType:
+			channel.chatId, n = protowire.ConsumeVarint(raw)
+		case num == 3 && typ == protowire.Fixed64Type:
+			sender, n = protowire.ConsumeFixed64(raw)
+		case num == 4 && typ == protowire.BytesType:
+			message, n = protowire.ConsumeString(raw)
+		case num == 5 && typ == protowire.VarintType:
+			timestamp, n = protowire.ConsumeVarint(raw)
+		}
+		return n
+	})
+	if err != nil {
+		s.emitParseError(packet, err)
+		return
+	}
+	if channel.groupId == 0 {
+		s.emitParseError(packet, errors.New("Chat room message without a chat group id"))
+		return
+	}
+	room, chatter := s.chatRoomSteamId(channel), steamid.SteamId(sender)
+	event := &ChatMsgEvent{
+		ChatRoomId:      SteamId(room),
+		ChatterId:       SteamId(chatter),
+		Message:         message,
+		EntryType:       EChatEntryType_ChatMsg,
+		Timestamp:       time.Now(),
+		ServerTimestamp: timestamp != 0,
+	}
+	if timestamp != 0 {
+		event.Timestamp = time.Unix(int64(timestamp), 0)
+	}
+	s.logMessage(room, event)
+	s.emit(event)
+	s.checkChatFlood(room, chatter)
+}
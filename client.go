@@ -54,6 +54,10 @@ type Client struct {
 	writeChan chan IMsg
 	writeBuf  *bytes.Buffer
 	heartbeat *time.Ticker
+
+	sentMutex   sync.Mutex // guarding sentQueue and sentRunning
+	sentQueue   []func()
+	sentRunning bool
 }
 
 type PacketHandler interface {
@@ -170,9 +174,9 @@ func (c *Client) ConnectToBind(addr *netutil.PortAddr, local *net.TCPAddr) {
 
 func (c *Client) Disconnect() {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
 
 	if c.conn == nil {
+		c.mutex.Unlock()
 		return
 	}
 
@@ -182,8 +186,10 @@ func (c *Client) Disconnect() {
 		c.heartbeat.Stop()
 	}
 	close(c.writeChan)
-	c.Emit(&DisconnectedEvent{})
+	c.mutex.Unlock()
 
+	c.Social.handleDisconnect()
+	c.Emit(&DisconnectedEvent{})
 }
 
 // Adds a message to the send queue. Modifications to the given message after
@@ -251,9 +257,49 @@ func (c *Client) writeLoop() {
 			c.Fatalf("Error writing message %v: %v", msg, err)
 			return
 		}
+		if notify, ok := msg.(*sentNotifyMsg); ok {
+			c.notifySent(notify.onSent)
+		}
+	}
+}
+
+// notifySent queues a sent notification. They are called in order on a separate goroutine,
+// so that the write loop never blocks on emitting events
+func (c *Client) notifySent(onSent func()) {
+	c.sentMutex.Lock()
+	c.sentQueue = append(c.sentQueue, onSent)
+	start := !c.sentRunning
+	c.sentRunning = true
+	c.sentMutex.Unlock()
+	if start {
+		go c.sentLoop()
+	}
+}
+
+// sentLoop calls the queued sent notifications until the queue is empty
+func (c *Client) sentLoop() {
+	for {
+		c.sentMutex.Lock()
+		queue := c.sentQueue
+		c.sentQueue = nil
+		if len(queue) == 0 {
+			c.sentRunning = false
+			c.sentMutex.Unlock()
+			return
+		}
+		c.sentMutex.Unlock()
+		for _, onSent := range queue {
+			onSent()
+		}
 	}
 }
 
+// sentNotifyMsg wraps a message to call onSent once it has been written to the connection
+type sentNotifyMsg struct {
+	IClientMsg
+	onSent func()
+}
+
 func (c *Client) heartbeatLoop(seconds time.Duration) {
 	if c.heartbeat != nil {
 		c.heartbeat.Stop()
@@ -5,6 +5,5 @@ go 1.24
 require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/golang/protobuf v1.5.4
+	google.golang.org/protobuf v1.33.0
 )
-
-require google.golang.org/protobuf v1.33.0 // indirect
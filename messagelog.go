@@ -0,0 +1,54 @@
+package steam
+
+import (
+	"github.com/anovokreschenov/go-steam/steamid"
+	"sync"
+)
+
+// messageLog keeps the last size messages of every conversation in a ring buffer
+type messageLog struct {
+	mutex sync.Mutex
+	size  int
+	byId  map[steamid.SteamId]*messageRing
+}
+
+type messageRing struct {
+	messages []ChatMsgEvent
+	next     int // index the next message is written to once the ring is full
+}
+
+func newMessageLog(size int) *messageLog {
+	return &messageLog{
+		size: size,
+		byId: make(map[steamid.SteamId]*messageRing),
+	}
+}
+
+func (l *messageLog) add(id steamid.SteamId, msg ChatMsgEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ring, ok := l.byId[id]
+	if !ok {
+		ring = &messageRing{messages: make([]ChatMsgEvent, 0, l.size)}
+		l.byId[id] = ring
+	}
+	if len(ring.messages) < l.size {
+		ring.messages = append(ring.messages, msg)
+		return
+	}
+	ring.messages[ring.next] = msg
+	ring.next = (ring.next + 1) % l.size
+}
+
+// get returns the logged messages of a conversation, oldest first
+func (l *messageLog) get(id steamid.SteamId) []ChatMsgEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ring, ok := l.byId[id]
+	if !ok {
+		return nil
+	}
+	messages := make([]ChatMsgEvent, 0, len(ring.messages))
+	messages = append(messages, ring.messages[ring.next:]...)
+	return append(messages, ring.messages[:ring.next]...)
+}
@@ -0,0 +1,88 @@
+package steam
+
+import (
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+)
+
+// PersonaFlagsBuilder combines EClientPersonaStateFlags for persona requests:
+//
+//	flags := steam.PersonaFlags().Name().Presence().GameInfo().Build()
+//	client.Social.RequestFriendListInfo(ids, flags)
+type PersonaFlagsBuilder struct {
+	flags EClientPersonaStateFlag
+}
+
+// PersonaFlags returns a builder without any flags set
+func PersonaFlags() PersonaFlagsBuilder {
+	return PersonaFlagsBuilder{}
+}
+
+func (b PersonaFlagsBuilder) with(flag EClientPersonaStateFlag) PersonaFlagsBuilder {
+	b.flags |= flag
+	return b
+}
+
+// Status requests the persona state
+func (b PersonaFlagsBuilder) Status() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_Status)
+}
+
+// Name requests the persona name
+func (b PersonaFlagsBuilder) Name() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_PlayerName)
+}
+
+// QueryPort requests the query port of the game server being played on
+func (b PersonaFlagsBuilder) QueryPort() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_QueryPort)
+}
+
+// SourceId requests the source SteamId
+func (b PersonaFlagsBuilder) SourceId() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_SourceID)
+}
+
+// Presence requests the avatar, last seen times and rich presence
+func (b PersonaFlagsBuilder) Presence() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_Presence)
+}
+
+// Metadata requests the persona's metadata
+func (b PersonaFlagsBuilder) Metadata() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_Metadata)
+}
+
+// LastSeen requests the last logon and logoff times
+func (b PersonaFlagsBuilder) LastSeen() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_LastSeen)
+}
+
+// ClanInfo requests the clan's info
+func (b PersonaFlagsBuilder) ClanInfo() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_ClanInfo)
+}
+
+// GameInfo requests the game being played
+func (b PersonaFlagsBuilder) GameInfo() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_GameExtraInfo)
+}
+
+// GameDataBlob requests the game's data blob
+func (b PersonaFlagsBuilder) GameDataBlob() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_GameDataBlob)
+}
+
+// ClanTag requests the clan tag
+func (b PersonaFlagsBuilder) ClanTag() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_ClanTag)
+}
+
+// Facebook requests the linked Facebook account
+func (b PersonaFlagsBuilder) Facebook() PersonaFlagsBuilder {
+	return b.with(EClientPersonaStateFlag_Facebook)
+}
+
+// Build returns the combined flags
+func (b PersonaFlagsBuilder) Build() EClientPersonaStateFlag {
+	return b.flags
+}
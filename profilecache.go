@@ -0,0 +1,44 @@
+package steam
+
+import (
+	"github.com/anovokreschenov/go-steam/steamid"
+	"sync"
+	"time"
+)
+
+// profileCache keeps the last successful profile info of every SteamId
+type profileCache struct {
+	mutex sync.Mutex
+	now   func() time.Time
+	byId  map[steamid.SteamId]cachedProfile
+}
+
+type cachedProfile struct {
+	info     ProfileInfoEvent
+	received time.Time
+}
+
+func newProfileCache(now func() time.Time) *profileCache {
+	return &profileCache{
+		now:  now,
+		byId: make(map[steamid.SteamId]cachedProfile),
+	}
+}
+
+func (c *profileCache) add(info *ProfileInfoEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.byId[info.SteamId] = cachedProfile{*info, c.now()}
+}
+
+// get returns a copy of the profile info of the given SteamId if it was received less than ttl ago
+func (c *profileCache) get(id steamid.SteamId, ttl time.Duration) (*ProfileInfoEvent, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.byId[id]
+	if !ok || c.now().Sub(cached.received) >= ttl {
+		return nil, false
+	}
+	info := cached.info
+	return &info, true
+}
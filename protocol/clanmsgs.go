@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/rwu"
+)
+
+// The clan messages below are missing from the generated steamlang messages
+
+// MsgClientAcknowledgeClanInvite accepts or declines an invite to join a clan
+type MsgClientAcknowledgeClanInvite struct {
+	SteamIdClan  SteamId
+	AcceptInvite bool
+}
+
+func (d *MsgClientAcknowledgeClanInvite) GetEMsg() EMsg {
+	return EMsg_ClientAcknowledgeClanInvite
+}
+
+func (d *MsgClientAcknowledgeClanInvite) Serialize(w io.Writer) error {
+	err := binary.Write(w, binary.LittleEndian, d.SteamIdClan)
+	if err != nil {
+		return err
+	}
+	return rwu.WriteBool(w, d.AcceptInvite)
+}
+
+func (d *MsgClientAcknowledgeClanInvite) Deserialize(r io.Reader) error {
+	t0, err := rwu.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+	d.SteamIdClan = SteamId(t0)
+	d.AcceptInvite, err = rwu.ReadBool(r)
+	return err
+}
+
+// MsgClientInviteUserToClan invites a user to join a clan
+type MsgClientInviteUserToClan struct {
+	SteamIdInvitee         SteamId
+	SteamIdClan            SteamId
+	OnlyOfficerCanInviteMe bool
+}
+
+func (d *MsgClientInviteUserToClan) GetEMsg() EMsg {
+	return EMsg_ClientInviteUserToClan
+}
+
+func (d *MsgClientInviteUserToClan) Serialize(w io.Writer) error {
+	err := binary.Write(w, binary.LittleEndian, d.SteamIdInvitee)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.LittleEndian, d.SteamIdClan)
+	if err != nil {
+		return err
+	}
+	return rwu.WriteBool(w, d.OnlyOfficerCanInviteMe)
+}
+
+func (d *MsgClientInviteUserToClan) Deserialize(r io.Reader) error {
+	t0, err := rwu.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+	d.SteamIdInvitee = SteamId(t0)
+	t1, err := rwu.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+	d.SteamIdClan = SteamId(t1)
+	d.OnlyOfficerCanInviteMe, err = rwu.ReadBool(r)
+	return err
+}
@@ -1,9 +1,11 @@
 package protocol
 
 import (
+	"encoding/hex"
 	"io"
 	"math"
 	"strconv"
+	"strings"
 
 	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
 )
@@ -35,6 +37,33 @@ type MessageBody interface {
 	GetEMsg() EMsg
 }
 
+// the display text of the persona states, in English
+var personaStateLabels = map[EPersonaState]string{
+	EPersonaState_Offline:        "Offline",
+	EPersonaState_Online:         "Online",
+	EPersonaState_Busy:           "Busy",
+	EPersonaState_Away:           "Away",
+	EPersonaState_Snooze:         "Snooze",
+	EPersonaState_LookingToTrade: "Looking to Trade",
+	EPersonaState_LookingToPlay:  "Looking to Play",
+	EPersonaState_Invisible:      "Invisible",
+}
+
+// PersonaStateLabel returns the English display text of a persona state, like "Looking to Play".
+// Unknown states are returned as "Unknown"
+func PersonaStateLabel(state EPersonaState) string {
+	if label, ok := personaStateLabels[state]; ok {
+		return label
+	}
+	return "Unknown"
+}
+
+// IsActuallyOnline returns whether a persona state means the user is online, including away,
+// snoozing, busy and looking to trade or play. Offline, invisible and unknown states are not
+func IsActuallyOnline(state EPersonaState) bool {
+	return state != EPersonaState_Offline && state != EPersonaState_Invisible && personaStateLabels[state] != ""
+}
+
 // the default details to request in most situations
 const EClientPersonaStateFlag_DefaultInfoRequest = EClientPersonaStateFlag_PlayerName |
 	EClientPersonaStateFlag_Presence | EClientPersonaStateFlag_SourceID |
@@ -42,6 +71,38 @@ const EClientPersonaStateFlag_DefaultInfoRequest = EClientPersonaStateFlag_Playe
 
 const DefaultAvatar = "fef49e7fa7e1997310d705b2a6158ff8dc1cdfeb"
 
+// the hash Steam sends for users without an avatar
+const zeroAvatar = "0000000000000000000000000000000000000000"
+
+// ValidAvatar returns whether the avatar is a SHA-1 hash of exactly 40 hex characters
+// that isn't the empty or all-zero hash Steam uses for no avatar
 func ValidAvatar(avatar string) bool {
-	return !(avatar == "0000000000000000000000000000000000000000" || len(avatar) != 40)
+	if len(avatar) != 40 || IsDefaultAvatar(avatar) {
+		return false
+	}
+	_, err := hex.DecodeString(avatar)
+	return err == nil
+}
+
+// The base URL of Steam's avatar images
+const AvatarBaseURL = "https://avatars.steamstatic.com/"
+
+// AvatarURL returns the URL of the full size image of an avatar hash.
+// The empty or all-zero hash returns the URL of the default avatar
+func AvatarURL(avatar string) string {
+	if IsDefaultAvatar(avatar) {
+		avatar = DefaultAvatar
+	}
+	return AvatarBaseURL + NormalizeAvatar(avatar) + "_full.jpg"
+}
+
+// NormalizeAvatar returns the canonical form of an avatar hash: lowercase without whitespace
+func NormalizeAvatar(avatar string) string {
+	return strings.ToLower(strings.Join(strings.Fields(avatar), ""))
+}
+
+// IsDefaultAvatar returns whether the hash is the empty or all-zero hash Steam uses for no avatar
+func IsDefaultAvatar(avatar string) bool {
+	avatar = NormalizeAvatar(avatar)
+	return avatar == "" || avatar == zeroAvatar
 }
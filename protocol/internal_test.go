@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"testing"
+
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+)
+
+func TestNormalizeAvatar(t *testing.T) {
+	avatar := NormalizeAvatar(" FEF49E7FA7E1997310D705B2A6158FF8DC1CDFEB\n")
+	if avatar != DefaultAvatar {
+		t.Fatalf("Expected %s, got %q", DefaultAvatar, avatar)
+	}
+}
+
+func TestIsDefaultAvatar(t *testing.T) {
+	if !IsDefaultAvatar("0000000000000000000000000000000000000000") {
+		t.Fatal("Expected the all-zero hash to be the default avatar")
+	}
+	if !IsDefaultAvatar("") {
+		t.Fatal("Expected an empty hash to be the default avatar")
+	}
+	if IsDefaultAvatar(DefaultAvatar) {
+		t.Fatal("Expected a real hash not to be the default avatar")
+	}
+	if ValidAvatar("0000000000000000000000000000000000000000") {
+		t.Fatal("Expected the all-zero hash to be invalid")
+	}
+}
+
+func TestValidAvatar(t *testing.T) {
+	tests := []struct {
+		avatar string
+		valid  bool
+	}{
+		{DefaultAvatar, true},
+		{"FEF49E7FA7E1997310D705B2A6158FF8DC1CDFEB", true},
+		{"", false},
+		{"fef49e7fa7e1997310d705b2a6158ff8dc1cdfe", false},
+		{"fef49e7fa7e1997310d705b2a6158ff8dc1cdfeb00", false},
+		{"zef49e7fa7e1997310d705b2a6158ff8dc1cdfeb", false},
+		{"fef49e7fa7e1997310d705b2a6158ff8dc1cdfe ", false},
+		{"0000000000000000000000000000000000000000", false},
+	}
+	for _, test := range tests {
+		if valid := ValidAvatar(test.avatar); valid != test.valid {
+			t.Errorf("ValidAvatar(%q) = %v, expected %v", test.avatar, valid, test.valid)
+		}
+	}
+}
+
+func TestAvatarURL(t *testing.T) {
+	if url := AvatarURL("ABCDEF0123456789ABCDEF0123456789ABCDEF01"); url != AvatarBaseURL+"abcdef0123456789abcdef0123456789abcdef01_full.jpg" {
+		t.Fatalf("Unexpected URL %s", url)
+	}
+	if url := AvatarURL(""); url != AvatarBaseURL+DefaultAvatar+"_full.jpg" {
+		t.Fatalf("Expected the default avatar, got %s", url)
+	}
+}
+
+func TestPersonaStateLabel(t *testing.T) {
+	tests := []struct {
+		state  EPersonaState
+		label  string
+		online bool
+	}{
+		{EPersonaState_Offline, "Offline", false},
+		{EPersonaState_Online, "Online", true},
+		{EPersonaState_Busy, "Busy", true},
+		{EPersonaState_Away, "Away", true},
+		{EPersonaState_Snooze, "Snooze", true},
+		{EPersonaState_LookingToTrade, "Looking to Trade", true},
+		{EPersonaState_LookingToPlay, "Looking to Play", true},
+		{EPersonaState_Invisible, "Invisible", false},
+		{EPersonaState_Max, "Unknown", false},
+		{EPersonaState(42), "Unknown", false},
+	}
+	for _, test := range tests {
+		if label := PersonaStateLabel(test.state); label != test.label {
+			t.Errorf("PersonaStateLabel(%d) = %q, expected %q", test.state, label, test.label)
+		}
+		if online := IsActuallyOnline(test.state); online != test.online {
+			t.Errorf("IsActuallyOnline(%d) = %v, expected %v", test.state, online, test.online)
+		}
+	}
+	if EPersonaState_Invisible == EPersonaState_Max || EPersonaState_Invisible.String() != "EPersonaState_Invisible" {
+		t.Fatalf("Expected Invisible to be a state of its own, got %v", EPersonaState_Invisible)
+	}
+}
@@ -3801,7 +3801,8 @@ const (
 	EPersonaState_Snooze         EPersonaState = 4
 	EPersonaState_LookingToTrade EPersonaState = 5
 	EPersonaState_LookingToPlay  EPersonaState = 6
-	EPersonaState_Max            EPersonaState = 7
+	EPersonaState_Invisible      EPersonaState = 7
+	EPersonaState_Max            EPersonaState = 8
 )
 
 var EPersonaState_name = map[EPersonaState]string{
@@ -3812,7 +3813,8 @@ var EPersonaState_name = map[EPersonaState]string{
 	4: "EPersonaState_Snooze",
 	5: "EPersonaState_LookingToTrade",
 	6: "EPersonaState_LookingToPlay",
-	7: "EPersonaState_Max",
+	7: "EPersonaState_Invisible",
+	8: "EPersonaState_Max",
 }
 
 func (e EPersonaState) String() string {
@@ -0,0 +1,60 @@
+package steam
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing perSecond events on average with bursts of up to burst events
+type rateLimiter struct {
+	mutex     sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last call
+func (r *rateLimiter) refill(now time.Time) {
+	r.tokens += now.Sub(r.last).Seconds() * r.perSecond
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+}
+
+// allow takes a token if one is available
+func (r *rateLimiter) allow() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// reserve takes a token and returns how long to wait until it is available
+func (r *rateLimiter) reserve() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.perSecond * float64(time.Second))
+}
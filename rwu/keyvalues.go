@@ -0,0 +1,81 @@
+package rwu
+
+import (
+	"io"
+)
+
+// Value types of the binary KeyValues format
+const (
+	KeyValuesNone    = 0 // starts a subsection
+	KeyValuesString  = 1
+	KeyValuesInt32   = 2
+	KeyValuesFloat32 = 3
+	KeyValuesPointer = 4
+	KeyValuesWString = 5
+	KeyValuesColor   = 6
+	KeyValuesUint64  = 7
+	KeyValuesEnd     = 8 // ends a subsection or the root
+	KeyValuesInt64   = 10
+)
+
+// KeyValuesWriter writes the binary KeyValues format, like the MessageObject of chat members.
+// The first error is kept and returned by Err, later writes do nothing
+type KeyValuesWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewKeyValuesWriter returns a writer that starts the root with the given name, like
+// "MessageObject". The root must be ended with End like any subsection
+func NewKeyValuesWriter(w io.Writer, name string) *KeyValuesWriter {
+	kv := &KeyValuesWriter{w: w}
+	kv.err = WriteString(w, name)
+	return kv
+}
+
+// BeginSection starts a subsection, which must be ended with End
+func (kv *KeyValuesWriter) BeginSection(key string) {
+	kv.writeKey(KeyValuesNone, key)
+}
+
+// End ends the current subsection or the root
+func (kv *KeyValuesWriter) End() {
+	if kv.err == nil {
+		kv.err = WriteUint8(kv.w, KeyValuesEnd)
+	}
+}
+
+func (kv *KeyValuesWriter) WriteString(key, value string) {
+	kv.writeKey(KeyValuesString, key)
+	if kv.err == nil {
+		kv.err = WriteString(kv.w, value)
+	}
+}
+
+func (kv *KeyValuesWriter) WriteInt32(key string, value int32) {
+	kv.writeKey(KeyValuesInt32, key)
+	if kv.err == nil {
+		kv.err = WriteInt32(kv.w, value)
+	}
+}
+
+func (kv *KeyValuesWriter) WriteUint64(key string, value uint64) {
+	kv.writeKey(KeyValuesUint64, key)
+	if kv.err == nil {
+		kv.err = WriteUint64(kv.w, value)
+	}
+}
+
+// Err returns the first error that occurred while writing
+func (kv *KeyValuesWriter) Err() error {
+	return kv.err
+}
+
+func (kv *KeyValuesWriter) writeKey(kvType uint8, key string) {
+	if kv.err == nil {
+		kv.err = WriteUint8(kv.w, kvType)
+	}
+	if kv.err == nil {
+		kv.err = WriteString(kv.w, key)
+	}
+}
@@ -3,6 +3,7 @@ package rwu
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -74,6 +75,25 @@ func ReadString(r io.Reader) (string, error) {
 	return string(c), err
 }
 
+// ReadStringN reads a null terminated string like ReadString, but returns an error
+// if no terminator is found within the first max bytes of the string
+func ReadStringN(r io.Reader, max int) (string, error) {
+	c := make([]byte, 0)
+	for {
+		var b byte
+		if err := binary.Read(r, binary.LittleEndian, &b); err != nil {
+			return string(c), err
+		}
+		if b == byte(0x0) {
+			return string(c), nil
+		}
+		if len(c) == max {
+			return string(c), fmt.Errorf("String is longer than %d bytes", max)
+		}
+		c = append(c, b)
+	}
+}
+
 func ReadByte(r io.Reader) (byte, error) {
 	var c byte
 	err := binary.Read(r, binary.LittleEndian, &c)
@@ -95,3 +115,25 @@ func WriteBool(w io.Writer, b bool) error {
 	}
 	return err
 }
+
+func WriteUint8(w io.Writer, c uint8) error {
+	return binary.Write(w, binary.LittleEndian, c)
+}
+
+func WriteUint32(w io.Writer, c uint32) error {
+	return binary.Write(w, binary.LittleEndian, c)
+}
+
+func WriteUint64(w io.Writer, c uint64) error {
+	return binary.Write(w, binary.LittleEndian, c)
+}
+
+func WriteInt32(w io.Writer, c int32) error {
+	return binary.Write(w, binary.LittleEndian, c)
+}
+
+// WriteString writes a null terminated string as read by ReadString
+func WriteString(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s+"\x00")
+	return err
+}
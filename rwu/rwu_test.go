@@ -0,0 +1,57 @@
+package rwu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadStringN(t *testing.T) {
+	r := bytes.NewBufferString("name\x00rest")
+	if s, err := ReadStringN(r, 8); err != nil || s != "name" {
+		t.Fatalf("Expected name, got %q, %v", s, err)
+	}
+	if r.String() != "rest" {
+		t.Fatalf("Expected the terminator to be consumed, %q is left", r.String())
+	}
+
+	atLimit := strings.Repeat("a", 8)
+	if s, err := ReadStringN(bytes.NewBufferString(atLimit+"\x00"), 8); err != nil || s != atLimit {
+		t.Fatalf("Expected a string at the limit to be read, got %q, %v", s, err)
+	}
+
+	overlong := bytes.NewBufferString(strings.Repeat("a", 1<<16))
+	if _, err := ReadStringN(overlong, 8); err == nil {
+		t.Fatal("Expected an error for an unterminated overlong string")
+	}
+	if overlong.Len() != 1<<16-9 {
+		t.Fatalf("Expected to stop reading after the limit, %d bytes are left", overlong.Len())
+	}
+
+	if _, err := ReadStringN(bytes.NewBufferString("short"), 8); err == nil {
+		t.Fatal("Expected an error for an unterminated string at the end of the input")
+	}
+}
+
+func TestKeyValuesWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	kv := NewKeyValuesWriter(buf, "MessageObject")
+	kv.WriteUint64("steamid", 0x0110000100000002)
+	kv.BeginSection("extra")
+	kv.WriteString("name", "nested")
+	kv.End()
+	kv.WriteInt32("Permissions", -1)
+	kv.End()
+	if kv.Err() != nil {
+		t.Fatal(kv.Err())
+	}
+
+	expected := []byte("MessageObject\x00" +
+		"\x07steamid\x00\x02\x00\x00\x00\x01\x00\x10\x01" +
+		"\x00extra\x00\x01name\x00nested\x00\x08" +
+		"\x02Permissions\x00\xff\xff\xff\xff" +
+		"\x08")
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("Expected %q, got %q", expected, buf.Bytes())
+	}
+}
@@ -0,0 +1,109 @@
+package steam
+
+import (
+	"errors"
+	. "github.com/anovokreschenov/go-steam/protocol"
+	. "github.com/anovokreschenov/go-steam/protocol/protobuf"
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+)
+
+// The policy applied to messages sent while the send queue is full
+type SendQueuePolicy int
+
+const (
+	// Reject the new message with ErrSendQueueFull
+	SendQueueDropNewest SendQueuePolicy = iota
+	// Drop the oldest queued message to make room for the new one
+	SendQueueDropOldest
+)
+
+// Returned by SendMessage when the send queue is full and its policy is SendQueueDropNewest
+var ErrSendQueueFull = errors.New("Message dropped because the send queue is full")
+
+// sendQueue buffers outgoing messages while the client isn't logged on
+type sendQueue struct {
+	max  int
+	msgs []IMsg
+}
+
+// push queues a message, applying the policy if the queue is full
+func (q *sendQueue) push(msg IMsg, policy SendQueuePolicy) error {
+	if len(q.msgs) >= q.max {
+		if policy == SendQueueDropNewest {
+			return ErrSendQueueFull
+		}
+		q.msgs = q.msgs[1:]
+	}
+	q.msgs = append(q.msgs, msg)
+	return nil
+}
+
+// drain removes and returns all queued messages, oldest first
+func (q *sendQueue) drain() []IMsg {
+	msgs := q.msgs
+	q.msgs = nil
+	return msgs
+}
+
+// EnableSendQueue buffers up to max messages passed to SendMessage while the client isn't
+// logged on, instead of dropping them. They are sent in order once logged on again.
+// Enabling it again clears the queue, a max of zero or less disables it
+func (s *Social) EnableSendQueue(max int) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if max <= 0 {
+		s.sendQueue = nil
+		return
+	}
+	s.sendQueue = &sendQueue{max: max}
+}
+
+// SetSendQueuePolicy sets whether the newest or the oldest message is dropped when the send queue is full
+func (s *Social) SetSendQueuePolicy(policy SendQueuePolicy) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	s.sendQueuePolicy = policy
+}
+
+// writeOrQueue writes a message, or queues it if the send queue is enabled and the client
+// isn't logged on or is still sending the queued messages. Must be called with sendMutex held
+func (s *Social) writeOrQueue(msg IMsg) error {
+	if s.sendQueue != nil && !s.loggedOn {
+		return s.sendQueue.push(msg, s.sendQueuePolicy)
+	}
+	s.client.Write(msg)
+	return nil
+}
+
+// handleLogOnResponse sends the queued messages once logged on. Messages sent meanwhile are
+// queued behind them, the client only counts as logged on once the queue is empty
+func (s *Social) handleLogOnResponse(packet *Packet) {
+	if !packet.IsProto {
+		return
+	}
+	body := new(CMsgClientLogonResponse)
+	packet.ReadProtoMsg(body)
+	if EResult(body.GetEresult()) != EResult_OK {
+		return
+	}
+	for {
+		s.sendMutex.Lock()
+		if s.sendQueue == nil || len(s.sendQueue.msgs) == 0 {
+			s.loggedOn = true
+			s.sendMutex.Unlock()
+			return
+		}
+		msg := s.sendQueue.msgs[0]
+		s.sendQueue.msgs = s.sendQueue.msgs[1:]
+		s.sendMutex.Unlock()
+		// don't hold the lock while Write blocks on a full write channel
+		s.client.Write(msg)
+	}
+}
+
+// setLoggedOff queues messages again until the next logon, called on disconnect and log off
+func (s *Social) setLoggedOff() {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	s.loggedOn = false
+}
@@ -2,8 +2,10 @@ package steam
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	. "github.com/anovokreschenov/go-steam/protocol"
 	. "github.com/anovokreschenov/go-steam/protocol/protobuf"
 	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
@@ -11,36 +13,170 @@ import (
 	"github.com/anovokreschenov/go-steam/socialcache"
 	"github.com/anovokreschenov/go-steam/steamid"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
 
+// The default maximum number of SteamIds sent in a single friend data request
+const DefaultFriendDataBatchSize = 100
+
+// The policy applied to messages sent faster than the send rate limit
+type SendRatePolicy int
+
+const (
+	// Block until the message may be sent
+	SendRateWait SendRatePolicy = iota
+	// Drop the message and return ErrSendRateLimited
+	SendRateReject
+)
+
+// The maximum length of a persona name in bytes
+const MaxPersonaNameLength = 128
+
+// Returned by SendMessage when a message was dropped by the send rate limit
+var ErrSendRateLimited = errors.New("Message dropped by send rate limit")
+
+// Returned by requests waiting for a response when Social has been closed
+var ErrSocialClosed = errors.New("Social has been closed")
+
+// The default pacing of RequestProfileInfoBatch in requests per second and burst size
+const (
+	DefaultProfileInfoRate  = 5.0
+	DefaultProfileInfoBurst = 5
+)
+
 // Social provides access to social aspects of Steam.
 type Social struct {
 	mutex sync.RWMutex
 
+	// The maximum number of SteamIds sent in a single friend data request,
+	// larger requests are split into several messages
+	FriendDataBatchSize int
+
 	name         string
+	pendingName  string
 	avatar       string
 	personaState EPersonaState
+	// Whether personaState was broadcast in the session personaSession,
+	// the server resets the state of each new session
+	personaSent    bool
+	personaSession int32
+
+	sendLimiter     *rateLimiter
+	sendRatePolicy  SendRatePolicy
+	sendMutex       sync.Mutex
+	sendSeq         uint64
+	sendQueue       *sendQueue
+	sendQueuePolicy SendQueuePolicy
+	loggedOn        bool // guarded by sendMutex, see handleLogOnResponse
+	autoAck         bool
+	emitSent        bool
+	messageLog      *messageLog
+	autoAway        *autoAway
+	chatFlood       *chatFlood
+
+	newChatRooms     bool
+	chatRoomChannels map[steamid.SteamId]chatRoomChannel
+
+	clanEventRequests map[steamid.SteamId]time.Time // when the events of a clan were requested
+
+	closeOnce      sync.Once
+	closed         bool
+	profileLimiter *rateLimiter
+
+	profileCache *profileCache
+	avatarCache  *avatarCache
+	avatarClient *http.Client
 
-	Friends *socialcache.FriendsList
-	Groups  *socialcache.GroupsList
-	Chats   *socialcache.ChatsList
+	packetHandlers map[EMsg][]customPacketHandler
+	nextHandlerId  int
+
+	groupInvites map[JobId]groupInvite
+	nameRequests map[steamid.SteamId]time.Time
+
+	Friends        *socialcache.FriendsList
+	Groups         *socialcache.GroupsList
+	Chats          *socialcache.ChatsList
+	Categories     *socialcache.CategoriesList
+	ChatRoomGroups *socialcache.ChatRoomGroupsList
+
+	profileWaiters   *responseWaiters
+	personaWaiters   *responseWaiters
+	chatEnterWaiters *responseWaiters
+	subscribers      *eventSubscribers
+	eventFilter      func(interface{}) bool
 
 	client *Client
 }
 
 func newSocial(client *Client) *Social {
 	return &Social{
-		Friends: socialcache.NewFriendsList(),
-		Groups:  socialcache.NewGroupsList(),
-		Chats:   socialcache.NewChatsList(),
-		client:  client,
+		FriendDataBatchSize: DefaultFriendDataBatchSize,
+		Friends:             socialcache.NewFriendsList(),
+		Groups:              socialcache.NewGroupsList(),
+		Chats:               socialcache.NewChatsList(),
+		Categories:          socialcache.NewCategoriesList(),
+		ChatRoomGroups:      socialcache.NewChatRoomGroupsList(),
+		profileLimiter:      newRateLimiter(DefaultProfileInfoRate, DefaultProfileInfoBurst),
+		profileCache:        newProfileCache(time.Now),
+		avatarCache:         newAvatarCache(DefaultAvatarCacheSize),
+		avatarClient:        http.DefaultClient,
+		profileWaiters:      newResponseWaiters(),
+		personaWaiters:      newResponseWaiters(),
+		chatEnterWaiters:    newResponseWaiters(),
+		subscribers:         newEventSubscribers(),
+		clanEventRequests:   make(map[steamid.SteamId]time.Time),
+		packetHandlers:      make(map[EMsg][]customPacketHandler),
+		groupInvites:        make(map[JobId]groupInvite),
+		nameRequests:        make(map[steamid.SteamId]time.Time),
+		chatRoomChannels:    make(map[steamid.SteamId]chatRoomChannel),
+		client:              client,
 	}
 }
 
-// GetAvatar the local user's avatar
+// Close stops the background goroutines of Social, like the auto-away timer, and makes
+// pending and future GetPersona, GetProfileInfo and JoinChatWait calls return ErrSocialClosed.
+// It is safe to call Close multiple times
+func (s *Social) Close() {
+	s.closeOnce.Do(func() {
+		s.mutex.Lock()
+		s.closed = true
+		s.mutex.Unlock()
+		s.DisableAutoAway()
+		s.personaWaiters.close(ErrSocialClosed)
+		s.profileWaiters.close(ErrSocialClosed)
+		s.chatEnterWaiters.close(ErrSocialClosed)
+	})
+}
+
+// handleDisconnect resets the state that only lasts for a connection,
+// called by the client when it disconnects
+func (s *Social) handleDisconnect() {
+	s.setLoggedOff()
+	s.mutex.Lock()
+	s.groupInvites = make(map[JobId]groupInvite)
+	s.nameRequests = make(map[steamid.SteamId]time.Time)
+	s.clanEventRequests = make(map[steamid.SteamId]time.Time)
+	s.mutex.Unlock()
+}
+
+// ClearCache removes all cached friends, groups, chats, friend categories and chat room groups,
+// for example after logging off. They are filled again by the next logon
+func (s *Social) ClearCache() {
+	s.Friends.Clear()
+	s.Groups.Clear()
+	s.Chats.Clear()
+	s.Categories.Clear()
+	s.ChatRoomGroups.Clear()
+}
+
+// GetAvatar the local user's avatar hash, empty if the account has no avatar.
+// Use AvatarURL to turn it into the URL of the image
 func (s *Social) GetAvatar() string {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -54,15 +190,46 @@ func (s *Social) GetPersonaName() string {
 	return s.name
 }
 
-// SetPersonaName the local user's persona name and broadcasts it over the network
-func (s *Social) SetPersonaName(name string) {
+// SetPersonaName the local user's persona name and broadcasts it over the network.
+// Returns an error without changing anything if the name is empty or longer than MaxPersonaNameLength bytes.
+// The name is only cached once the server accepts it, you'll receive a PersonaNameChangeEvent with the result
+func (s *Social) SetPersonaName(name string) error {
+	if err := validatePersonaName(name); err != nil {
+		return err
+	}
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.name = name
-	s.client.Write(NewClientMsgProtobuf(EMsg_ClientChangeStatus, &CMsgClientChangeStatus{
-		PersonaState: proto.Uint32(uint32(s.personaState)),
+	s.writePersonaChange(name, s.personaState)
+	return nil
+}
+
+// writePersonaChange sends a name and state change, the caller must hold the lock
+func (s *Social) writePersonaChange(name string, state EPersonaState) {
+	s.pendingName = name
+	msg := NewClientMsgProtobuf(EMsg_ClientChangeStatus, &CMsgClientChangeStatus{
+		PersonaState: proto.Uint32(uint32(state)),
 		PlayerName:   proto.String(name),
-	}))
+	})
+	msg.SetSourceJobId(s.client.GetNextJobId())
+	s.client.Write(msg)
+	s.markPersonaSent()
+}
+
+// markPersonaSent records that the cached persona state was broadcast in the current session,
+// the caller must hold the lock
+func (s *Social) markPersonaSent() {
+	s.personaSent = true
+	s.personaSession = s.client.SessionId()
+}
+
+func validatePersonaName(name string) error {
+	if name == "" {
+		return errors.New("Persona name must not be empty")
+	}
+	if len(name) > MaxPersonaNameLength {
+		return fmt.Errorf("Persona name is %d bytes long, the maximum is %d", len(name), MaxPersonaNameLength)
+	}
+	return nil
 }
 
 // GetPersonaState the local user's persona state
@@ -72,34 +239,319 @@ func (s *Social) GetPersonaState() EPersonaState {
 	return s.personaState
 }
 
-// SetPersonaState the local user's persona state and broadcasts it over the network
+// SetPersonaState the local user's persona state and broadcasts it over the network.
+// Nothing is sent if the state was already broadcast in the current session, use
+// ForceSetPersonaState to broadcast it anyway.
+// Use EPersonaState_Invisible to appear offline while staying connected
 func (s *Social) SetPersonaState(state EPersonaState) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if s.personaSent && s.personaState == state && s.personaSession == s.client.SessionId() {
+		return
+	}
+	s.writePersonaState(state)
+}
+
+// ForceSetPersonaState sets the local user's persona state and broadcasts it over the network,
+// even if it is unchanged
+func (s *Social) ForceSetPersonaState(state EPersonaState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writePersonaState(state)
+}
+
+// writePersonaState sends a state change, the caller must hold the lock
+func (s *Social) writePersonaState(state EPersonaState) {
 	s.personaState = state
 	s.client.Write(NewClientMsgProtobuf(EMsg_ClientChangeStatus, &CMsgClientChangeStatus{
 		PersonaState: proto.Uint32(uint32(state)),
 	}))
+	s.markPersonaSent()
+}
+
+// EnableAutoAway switches the persona state to Away after awayAfter and to Snooze after
+// snoozeAfter without activity, see NotifyActivity. A duration of zero or less skips that state.
+// The previous persona state is restored on activity
+func (s *Social) EnableAutoAway(awayAfter, snoozeAfter time.Duration) {
+	s.enableAutoAway(newAutoAway(s, awayAfter, snoozeAfter, time.Now), autoAwayCheckInterval)
+}
+
+func (s *Social) enableAutoAway(a *autoAway, interval time.Duration) {
+	s.DisableAutoAway()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.autoAway = a
+	go a.run(interval)
+}
+
+// DisableAutoAway stops the auto-away timer. The current persona state is kept
+func (s *Social) DisableAutoAway() {
+	s.mutex.Lock()
+	a := s.autoAway
+	s.autoAway = nil
+	s.mutex.Unlock()
+	if a != nil {
+		a.close()
+	}
+}
+
+// NotifyActivity resets the auto-away timer and restores the persona state if the user
+// was set away automatically
+func (s *Social) NotifyActivity() {
+	s.mutex.RLock()
+	a := s.autoAway
+	s.mutex.RUnlock()
+	if a != nil {
+		a.notifyActivity()
+	}
+}
+
+// SetPersona sets the local user's persona name and state and broadcasts both over the
+// network in a single message. Like with SetPersonaName, the name is only cached once the
+// server accepts it
+func (s *Social) SetPersona(name string, state EPersonaState) error {
+	if err := validatePersonaName(name); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.personaState = state
+	s.writePersonaChange(name, state)
+	return nil
+}
+
+// SetSendRateLimit limits SendMessage to perSecond messages per second with bursts of up
+// to burst messages. A perSecond of zero or less removes the limit
+func (s *Social) SetSendRateLimit(perSecond float64, burst int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if perSecond <= 0 {
+		s.sendLimiter = nil
+		return
+	}
+	s.sendLimiter = newRateLimiter(perSecond, burst)
+}
+
+// SetChatFloodThreshold emits a ChatFloodEvent when a chat member sends more than count
+// messages to a room within window. A count or window of zero or less disables the detection
+func (s *Social) SetChatFloodThreshold(count int, window time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if count <= 0 || window <= 0 {
+		s.chatFlood = nil
+		return
+	}
+	s.chatFlood = newChatFlood(count, window, time.Now)
+}
+
+// SetSendRatePolicy sets whether messages exceeding the send rate limit are delayed or dropped
+func (s *Social) SetSendRatePolicy(policy SendRatePolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendRatePolicy = policy
+}
+
+// throttleSend applies the send rate limit, returning ErrSendRateLimited if the message should be dropped
+func (s *Social) throttleSend() error {
+	s.mutex.RLock()
+	limiter, policy := s.sendLimiter, s.sendRatePolicy
+	s.mutex.RUnlock()
+	if limiter == nil {
+		return nil
+	}
+	if policy == SendRateReject {
+		if !limiter.allow() {
+			return ErrSendRateLimited
+		}
+		return nil
+	}
+	time.Sleep(limiter.reserve())
+	return nil
 }
 
-// SendMessage a chat message to ether a room or friend
-func (s *Social) SendMessage(to steamid.SteamId, entryType EChatEntryType, message string) {
+// SendMessage a chat message to ether a room or friend.
+// Returns ErrSendRateLimited if the message was dropped by the send rate limit, or
+// ErrSendQueueFull if it was dropped by a full send queue, see EnableSendQueue
+func (s *Social) SendMessage(to steamid.SteamId, entryType EChatEntryType, message string) error {
+	_, err := s.SendMessageWithId(to, entryType, message)
+	return err
+}
+
+// SendMessageWithId sends a chat message like SendMessage and returns its local message id.
+// Ids increase in the order messages are sent, starting at 1. A MessageSentEvent with the id
+// is emitted once the message has been written to the connection
+func (s *Social) SendMessageWithId(to steamid.SteamId, entryType EChatEntryType, message string) (uint64, error) {
+	if err := s.throttleSend(); err != nil {
+		return 0, err
+	}
+	var msg IClientMsg
 	//Friend
 	if to.GetAccountType() == EAccountType_Individual || to.GetAccountType() == EAccountType_ConsoleUser {
-		s.client.Write(NewClientMsgProtobuf(EMsg_ClientFriendMsg, &CMsgClientFriendMsg{
+		msg = NewClientMsgProtobuf(EMsg_ClientFriendMsg, &CMsgClientFriendMsg{
 			Steamid:       proto.Uint64(to.ToUint64()),
 			ChatEntryType: proto.Int32(int32(entryType)),
 			Message:       []byte(message),
-		}))
+		})
 		//Chat room
 	} else if to.GetAccountType() == EAccountType_Clan || to.GetAccountType() == EAccountType_Chat {
-		chatID := to.ClanToChat()
-		s.client.Write(NewClientMsg(&MsgClientChatMsg{
-			ChatMsgType:     entryType,
-			SteamIdChatRoom: SteamId(chatID),
-			SteamIdChatter:  SteamId(s.client.SteamId()),
-		}, []byte(message)))
+		to = to.ClanToChat()
+		if channel, ok := s.chatRoomChannel(to); ok && entryType == EChatEntryType_ChatMsg {
+			msg = newChatRoomMsg(channel, message)
+		} else {
+			msg = NewClientMsg(&MsgClientChatMsg{
+				ChatMsgType:     entryType,
+				SteamIdChatRoom: SteamId(to),
+				SteamIdChatter:  SteamId(s.client.SteamId()),
+			}, []byte(message))
+		}
+	} else {
+		return 0, fmt.Errorf("Messages can't be sent to %v", to.GetAccountType())
+	}
+	// hold the lock while writing so that ids are in the order the messages are queued
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	s.sendSeq++
+	id := s.sendSeq
+	err := s.writeOrQueue(&sentNotifyMsg{msg, func() {
+		s.emit(&MessageSentEvent{
+			Id:          id,
+			RecipientId: to,
+			EntryType:   entryType,
+			Message:     message,
+		})
+		s.emitSentMessage(to, entryType, message)
+	}})
+	if err != nil {
+		s.sendSeq--
+		return 0, err
+	}
+	return id, nil
+}
+
+// EmitSentMessages sets whether a ChatMsgEvent with Outgoing set is emitted for every message
+// sent with SendMessage, so that consumers logging messages also see our own. Like the
+// MessageSentEvent, it is emitted once the message has been written to the connection,
+// messages waiting in the send queue or dropped from it aren't reported
+func (s *Social) EmitSentMessages(emit bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.emitSent = emit
+}
+
+// emitSentMessage emits a ChatMsgEvent for a sent message if EmitSentMessages is enabled
+func (s *Social) emitSentMessage(to steamid.SteamId, entryType EChatEntryType, message string) {
+	s.mutex.RLock()
+	emitSent := s.emitSent
+	s.mutex.RUnlock()
+	if !emitSent {
+		return
+	}
+	event := &ChatMsgEvent{
+		ChatterId: SteamId(s.client.SteamId()),
+		Message:   message,
+		EntryType: entryType,
+		Timestamp: time.Now(),
+		Outgoing:  true,
+	}
+	if to.GetAccountType() == EAccountType_Chat {
+		event.ChatRoomId = SteamId(to)
+	} else {
+		event.RecipientId = SteamId(to)
+	}
+	s.emit(event)
+}
+
+// SendEmote sends a /me-style emote message to a friend or chat room
+func (s *Social) SendEmote(to steamid.SteamId, message string) error {
+	return s.SendMessage(to, EChatEntryType_Emote, message)
+}
+
+// SendMessageToGroup sends a chat message to the group chat of a clan.
+// Returns an error if the given SteamId isn't a clan
+func (s *Social) SendMessageToGroup(clanId steamid.SteamId, entryType EChatEntryType, message string) error {
+	if !clanId.IsClan() {
+		return fmt.Errorf("%v is not a clan but %v", clanId, clanId.GetAccountType())
+	}
+	return s.SendMessage(clanId.ClanToChat(), entryType, message)
+}
+
+// SendGameInvite sends a game invite with the given connect string to a friend.
+// Game invites can't be sent to chat rooms
+func (s *Social) SendGameInvite(to steamid.SteamId, connectString string) error {
+	if to.GetAccountType() != EAccountType_Individual && to.GetAccountType() != EAccountType_ConsoleUser {
+		return fmt.Errorf("Game invites can only be sent to friends, not %v", to.GetAccountType())
+	}
+	return s.SendMessage(to, EChatEntryType_InviteGame, connectString)
+}
+
+// SetAutoAckFriendMessages sets whether incoming friend messages are acknowledged
+// automatically, see AckFriendMessage
+func (s *Social) SetAutoAckFriendMessages(autoAck bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.autoAck = autoAck
+}
+
+// EnableMessageLog keeps the last size received messages and emotes of every friend and chat
+// room in memory, see GetMessageLog. Enabling it again clears the log, a size of zero or less disables it
+func (s *Social) EnableMessageLog(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if size <= 0 {
+		s.messageLog = nil
+		return
 	}
+	s.messageLog = newMessageLog(size)
+}
+
+// GetMessageLog returns the logged messages of a friend or chat room, oldest first.
+// Returns nil if the message log isn't enabled
+func (s *Social) GetMessageLog(id steamid.SteamId) []ChatMsgEvent {
+	s.mutex.RLock()
+	log := s.messageLog
+	s.mutex.RUnlock()
+	if log == nil {
+		return nil
+	}
+	return log.get(id)
+}
+
+// logMessage adds a received message to the message log of the given conversation if it is enabled.
+// Only chat messages and emotes are logged, so typing notifications can't evict them
+func (s *Social) logMessage(id steamid.SteamId, msg *ChatMsgEvent) {
+	if msg.EntryType != EChatEntryType_ChatMsg && msg.EntryType != EChatEntryType_Emote {
+		return
+	}
+	s.mutex.RLock()
+	log := s.messageLog
+	s.mutex.RUnlock()
+	if log != nil {
+		log.add(id, *msg)
+	}
+}
+
+// AckFriendMessage marks all messages received from a friend so far as read,
+// which clears the unread state on the sender's side
+func (s *Social) AckFriendMessage(from steamid.SteamId) {
+	s.ackFriendMessage(from, uint32(time.Now().Unix()))
+}
+
+// The FriendMessages.AckMessage notification isn't part of the generated protobufs,
+// so its body (steamid_partner = 1, timestamp = 2) is encoded by hand
+func (s *Social) ackFriendMessage(from steamid.SteamId, timestamp uint32) {
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.Fixed64Type)
+	body = protowire.AppendFixed64(body, from.ToUint64())
+	body = protowire.AppendTag(body, 2, protowire.VarintType)
+	body = protowire.AppendVarint(body, uint64(timestamp))
+	s.client.Write(NewClientMsgProtobuf(EMsg_ClientServiceMethod, &CMsgClientServiceMethod{
+		MethodName:       proto.String("FriendMessages.AckMessage#1"),
+		SerializedMethod: body,
+		IsNotification:   proto.Bool(true),
+	}))
 }
 
 // AddFriend a friend to your friends list or accepts a friend. You'll receive a FriendStateEvent
@@ -110,6 +562,31 @@ func (s *Social) AddFriend(id steamid.SteamId) {
 	}))
 }
 
+// AddFriends sends friend requests to several SteamIds, skipping those that are already friends.
+// The requests are paced by the send rate limit, so this blocks until all have been sent
+func (s *Social) AddFriends(ids []steamid.SteamId) {
+	for _, id := range ids {
+		if friend, err := s.Friends.ById(id); err == nil && friend.Relationship == EFriendRelationship_Friend {
+			continue
+		}
+		s.mutex.RLock()
+		limiter := s.sendLimiter
+		s.mutex.RUnlock()
+		if limiter != nil {
+			time.Sleep(limiter.reserve())
+		}
+		s.AddFriend(id)
+	}
+}
+
+// AddFriendByName sends a friend request to the account with the given account name or e-mail.
+// The FriendAddedEvent carries the resolved SteamId and persona name
+func (s *Social) AddFriendByName(name string) {
+	s.client.Write(NewClientMsgProtobuf(EMsg_ClientAddFriend, &CMsgClientAddFriend{
+		AccountnameOrEmailToAdd: proto.String(name),
+	}))
+}
+
 // RemoveFriend removes a friend from your friends list
 func (s *Social) RemoveFriend(id steamid.SteamId) {
 	s.client.Write(NewClientMsgProtobuf(EMsg_ClientRemoveFriend, &CMsgClientRemoveFriend{
@@ -117,6 +594,97 @@ func (s *Social) RemoveFriend(id steamid.SteamId) {
 	}))
 }
 
+// PruneFriends removes all cached friends with the given relationship, e.g. EFriendRelationship_RequestInitiator
+// to withdraw all outgoing friend requests. Returns the number of friends removed
+func (s *Social) PruneFriends(rel EFriendRelationship) int {
+	count := 0
+	for id, friend := range s.Friends.GetCopy() {
+		if friend.Relationship == rel {
+			s.RemoveFriend(id)
+			count++
+		}
+	}
+	return count
+}
+
+// JoinGroup accepts a pending invite to join a group. The cached relationship is updated
+// once the server confirms the membership, you'll receive a GroupStateEvent
+func (s *Social) JoinGroup(clan steamid.SteamId) {
+	s.client.Write(NewClientMsg(&MsgClientAcknowledgeClanInvite{
+		SteamIdClan:  SteamId(clan.ChatToClan()),
+		AcceptInvite: true,
+	}, make([]byte, 0)))
+}
+
+// LeaveGroup leaves a group. There is no dedicated message for this, the group is removed
+// like a friend. The group is removed from the cache once the server confirms it, you'll
+// receive a GroupStateEvent
+func (s *Social) LeaveGroup(clan steamid.SteamId) {
+	s.RemoveFriend(clan.ChatToClan())
+}
+
+// A pending InviteToGroup request
+type groupInvite struct {
+	clan, invitee steamid.SteamId
+	sent          time.Time
+}
+
+// How long an InviteToGroup request waits for its response before it's forgotten
+const groupInviteTimeout = time.Minute
+
+// InviteToGroup invites a user to join a group, which requires permission to invite members.
+// You'll receive a GroupInviteResultEvent with the result
+func (s *Social) InviteToGroup(clan steamid.SteamId, user steamid.SteamId) {
+	clan = clan.ChatToClan()
+	msg := NewClientMsg(&MsgClientInviteUserToClan{
+		SteamIdInvitee: SteamId(user),
+		SteamIdClan:    SteamId(clan),
+	}, make([]byte, 0))
+	jobId := s.client.GetNextJobId()
+	msg.SetSourceJobId(jobId)
+	now := time.Now()
+	s.mutex.Lock()
+	for id, invite := range s.groupInvites {
+		if now.Sub(invite.sent) > groupInviteTimeout {
+			delete(s.groupInvites, id)
+		}
+	}
+	s.groupInvites[jobId] = groupInvite{clan, user, now}
+	s.mutex.Unlock()
+	s.client.Write(msg)
+}
+
+// AcceptFriendRequest accepts a pending incoming friend request.
+// Returns an error if there is no pending request from the given SteamId
+func (s *Social) AcceptFriendRequest(id steamid.SteamId) error {
+	if err := s.checkPendingIncoming(id); err != nil {
+		return err
+	}
+	s.AddFriend(id)
+	return nil
+}
+
+// DeclineFriendRequest declines a pending incoming friend request.
+// Returns an error if there is no pending request from the given SteamId
+func (s *Social) DeclineFriendRequest(id steamid.SteamId) error {
+	if err := s.checkPendingIncoming(id); err != nil {
+		return err
+	}
+	s.RemoveFriend(id)
+	return nil
+}
+
+func (s *Social) checkPendingIncoming(id steamid.SteamId) error {
+	friend, err := s.Friends.ById(id)
+	if err != nil {
+		return err
+	}
+	if friend.Relationship != EFriendRelationship_RequestRecipient {
+		return fmt.Errorf("No pending friend request from %v (relationship is %v)", id, friend.Relationship)
+	}
+	return nil
+}
+
 // IgnoreFriend ignores or unignores a friend on Steam
 func (s *Social) IgnoreFriend(id steamid.SteamId, setIgnore bool) {
 	ignore := uint8(1) //True
@@ -130,16 +698,41 @@ func (s *Social) IgnoreFriend(id steamid.SteamId, setIgnore bool) {
 	}, make([]byte, 0)))
 }
 
-// RequestFriendListInfo requests persona state for a list of specified SteamIds
+// RequestFriendListInfo requests persona state for a list of specified SteamIds.
+// Duplicate ids are only requested once. The ids are sent in batches of at most FriendDataBatchSize
 func (s *Social) RequestFriendListInfo(ids []steamid.SteamId, requestedInfo EClientPersonaStateFlag) {
-	var friends []uint64
+	ids = uniqueIds(ids)
+	batchSize := s.FriendDataBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultFriendDataBatchSize
+	}
+	for start := 0; start == 0 || start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var friends []uint64
+		for _, id := range ids[start:end] {
+			friends = append(friends, id.ToUint64())
+		}
+		s.client.Write(NewClientMsgProtobuf(EMsg_ClientRequestFriendData, &CMsgClientRequestFriendData{
+			PersonaStateRequested: proto.Uint32(uint32(requestedInfo)),
+			Friends:               friends,
+		}))
+	}
+}
+
+// uniqueIds returns the ids without duplicates, keeping the order of their first occurrence
+func uniqueIds(ids []steamid.SteamId) []steamid.SteamId {
+	seen := make(map[steamid.SteamId]bool, len(ids))
+	unique := make([]steamid.SteamId, 0, len(ids))
 	for _, id := range ids {
-		friends = append(friends, id.ToUint64())
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
 	}
-	s.client.Write(NewClientMsgProtobuf(EMsg_ClientRequestFriendData, &CMsgClientRequestFriendData{
-		PersonaStateRequested: proto.Uint32(uint32(requestedInfo)),
-		Friends:               friends,
-	}))
+	return unique
 }
 
 // RequestFriendInfo requests persona state for a specified SteamId
@@ -147,6 +740,164 @@ func (s *Social) RequestFriendInfo(id steamid.SteamId, requestedInfo EClientPers
 	s.RequestFriendListInfo([]steamid.SteamId{id}, requestedInfo)
 }
 
+// RequestClanEvents requests the upcoming events of a clan. There is no dedicated client
+// message for this, the clan state is requested instead and you'll receive a ClanEventsEvent
+// with the events it carries. Returns an error if the given SteamId isn't a clan
+func (s *Social) RequestClanEvents(clan steamid.SteamId) error {
+	clan = clan.ChatToClan()
+	if !clan.IsClan() {
+		return fmt.Errorf("%v is not a clan but %v", clan, clan.GetAccountType())
+	}
+	now := time.Now()
+	s.mutex.Lock()
+	for id, requested := range s.clanEventRequests {
+		if now.Sub(requested) > clanEventsTimeout {
+			delete(s.clanEventRequests, id)
+		}
+	}
+	s.clanEventRequests[clan] = now
+	s.mutex.Unlock()
+	s.RequestFriendInfo(clan, EClientPersonaStateFlag_ClanInfo)
+	return nil
+}
+
+// How long RequestClanEvents waits for the clan state before the request is forgotten
+const clanEventsTimeout = time.Minute
+
+// The persona state flags requested to get a clan's name, avatar and member counts
+const clanStateFlags = EClientPersonaStateFlag_PlayerName | EClientPersonaStateFlag_Presence | EClientPersonaStateFlag_ClanInfo
+
+// RequestClanState requests the current state of a single clan, you'll receive a ClanStateEvent
+// and the cached group is updated. Useful right after joining a group.
+// Returns an error if the given SteamId isn't a clan
+func (s *Social) RequestClanState(clan steamid.SteamId) error {
+	clan = clan.ChatToClan()
+	if !clan.IsClan() {
+		return fmt.Errorf("%v is not a clan but %v", clan, clan.GetAccountType())
+	}
+	s.RequestFriendInfo(clan, clanStateFlags)
+	return nil
+}
+
+// RequestAllGroupInfo requests the clan state of every cached group, one request per group.
+// Useful to populate group names and member counts right after logging in
+func (s *Social) RequestAllGroupInfo() {
+	for id := range s.Groups.GetCopy() {
+		s.RequestFriendInfo(id, clanStateFlags)
+	}
+}
+
+// GetPersona requests persona state for a specified SteamId and blocks until the matching
+// PersonaStateEvent arrives or the context is done. The PersonaStateEvent is emitted as usual
+func (s *Social) GetPersona(ctx context.Context, id steamid.SteamId, requestedInfo EClientPersonaStateFlag) (*PersonaStateEvent, error) {
+	ch := s.personaWaiters.add(id)
+	defer s.personaWaiters.remove(id, ch)
+	s.RequestFriendInfo(id, requestedInfo)
+	select {
+	case event := <-ch:
+		if err, ok := event.(error); ok {
+			return nil, err
+		}
+		return event.(*PersonaStateEvent), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// How long GetChatMemberName waits for a requested name before requesting it again
+const nameRequestTimeout = 30 * time.Second
+
+// GetChatMemberName returns the persona name of a chat member from the friends or chat member
+// cache. If the name isn't cached, it is requested and an empty string is returned, you'll
+// receive a PersonaStateEvent once it arrives. A name is only requested once while the request is pending
+func (s *Social) GetChatMemberName(member steamid.SteamId) string {
+	if member.Equal(s.client.SteamId()) {
+		return s.GetPersonaName()
+	}
+	if friend, err := s.Friends.ById(member); err == nil && friend.Name != "" {
+		return friend.Name
+	}
+	if name, err := s.Chats.FindMemberName(member); err == nil {
+		return name
+	}
+	now := time.Now()
+	s.mutex.Lock()
+	for id, requested := range s.nameRequests {
+		if now.Sub(requested) > nameRequestTimeout {
+			delete(s.nameRequests, id)
+		}
+	}
+	_, pending := s.nameRequests[member]
+	if !pending {
+		s.nameRequests[member] = now
+	}
+	s.mutex.Unlock()
+	if !pending {
+		s.RequestFriendInfo(member, EClientPersonaStateFlag_PlayerName)
+	}
+	return ""
+}
+
+// The largest avatar image FetchAvatar downloads, full size avatars are far smaller
+const maxAvatarSize = 1 << 20
+
+// FetchAvatar downloads the full size avatar image of the local user, a friend or a group using
+// the avatar hash in the cache. Users without an avatar get the default avatar. Images are kept
+// in memory, so fetching the same avatar again doesn't download it twice
+func (s *Social) FetchAvatar(ctx context.Context, id steamid.SteamId) ([]byte, error) {
+	hash, err := s.avatarHash(id)
+	if err != nil {
+		return nil, err
+	}
+	if IsDefaultAvatar(hash) {
+		hash = DefaultAvatar
+	}
+	hash = NormalizeAvatar(hash)
+	if data, ok := s.avatarCache.get(hash); ok {
+		return data, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", AvatarURL(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.avatarClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Avatar download failed with status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAvatarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAvatarSize {
+		return nil, fmt.Errorf("Avatar is larger than %d bytes", maxAvatarSize)
+	}
+	s.avatarCache.add(hash, data)
+	return data, nil
+}
+
+// avatarHash returns the cached avatar hash of the local user, a friend or a group
+func (s *Social) avatarHash(id steamid.SteamId) (string, error) {
+	if id.Equal(s.client.SteamId()) {
+		return s.GetAvatar(), nil
+	}
+	if id.IsClan() || id.IsChat() {
+		group, err := s.Groups.ById(id)
+		if err != nil {
+			return "", err
+		}
+		return group.Avatar, nil
+	}
+	friend, err := s.Friends.ById(id)
+	if err != nil {
+		return "", err
+	}
+	return friend.Avatar, nil
+}
+
 // RequestProfileInfo requests profile information for a specified SteamId
 func (s *Social) RequestProfileInfo(id steamid.SteamId) {
 	s.client.Write(NewClientMsgProtobuf(EMsg_ClientFriendProfileInfo, &CMsgClientFriendProfileInfo{
@@ -154,6 +905,62 @@ func (s *Social) RequestProfileInfo(id steamid.SteamId) {
 	}))
 }
 
+// RequestProfileInfoBatch requests profile information for several SteamIds, one request per id.
+// The requests are paced by the profile info rate limit, so this blocks until all have been sent
+func (s *Social) RequestProfileInfoBatch(ids []steamid.SteamId) {
+	for _, id := range ids {
+		s.mutex.RLock()
+		limiter := s.profileLimiter
+		s.mutex.RUnlock()
+		if limiter != nil {
+			time.Sleep(limiter.reserve())
+		}
+		s.RequestProfileInfo(id)
+	}
+}
+
+// SetProfileInfoRateLimit sets the pacing of RequestProfileInfoBatch to perSecond requests per
+// second with bursts of up to burst requests. A perSecond of zero or less removes the pacing
+func (s *Social) SetProfileInfoRateLimit(perSecond float64, burst int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if perSecond <= 0 {
+		s.profileLimiter = nil
+		return
+	}
+	s.profileLimiter = newRateLimiter(perSecond, burst)
+}
+
+// GetProfileInfo requests profile information for a specified SteamId and blocks until
+// the response arrives or the context is done. The ProfileInfoEvent is emitted as usual
+func (s *Social) GetProfileInfo(ctx context.Context, id steamid.SteamId) (*ProfileInfoEvent, error) {
+	ch := s.profileWaiters.add(id)
+	defer s.profileWaiters.remove(id, ch)
+	s.RequestProfileInfo(id)
+	select {
+	case event := <-ch:
+		if err, ok := event.(error); ok {
+			return nil, err
+		}
+		info := event.(*ProfileInfoEvent)
+		if info.Result != EResult_OK {
+			return info, fmt.Errorf("Profile info request for %v failed: %v", id, info.Result)
+		}
+		return info, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetProfileInfoCached returns the profile information of a SteamId received less than ttl ago,
+// by any request, without contacting the server. Otherwise it is requested like with GetProfileInfo
+func (s *Social) GetProfileInfoCached(ctx context.Context, id steamid.SteamId, ttl time.Duration) (*ProfileInfoEvent, error) {
+	if info, ok := s.profileCache.get(id, ttl); ok {
+		return info, nil
+	}
+	return s.GetProfileInfo(ctx, id)
+}
+
 // RequestOfflineMessages requests all offline messages and marks them as read
 /* TODO: Determine if this is possible to re-implement
 func (s *Social) RequestOfflineMessages() {
@@ -161,27 +968,56 @@ func (s *Social) RequestOfflineMessages() {
 }
 */
 
-// JoinChat attempts to join a chat room
+// JoinChat attempts to join a chat room. The chat is marked as joined in the cache right away
 func (s *Social) JoinChat(id steamid.SteamId) {
 	chatID := id.ClanToChat()
+	s.Chats.Add(socialcache.Chat{SteamId: chatID, Joined: true})
 	s.client.Write(NewClientMsg(&MsgClientJoinChat{
 		SteamIdChat: SteamId(chatID),
 	}, make([]byte, 0)))
 }
 
-// LeaveChat attempts to leave a chat room
+// JoinChatWait joins a chat room and blocks until the ChatEnterEvent for it arrives or ctx is done.
+// Returns an error along with the event if entering the chat room failed
+func (s *Social) JoinChatWait(ctx context.Context, id steamid.SteamId) (*ChatEnterEvent, error) {
+	chatID := id.ClanToChat()
+	ch := s.chatEnterWaiters.add(chatID)
+	defer s.chatEnterWaiters.remove(chatID, ch)
+	s.JoinChat(chatID)
+	select {
+	case response := <-ch:
+		if err, ok := response.(error); ok {
+			return nil, err
+		}
+		event := response.(*ChatEnterEvent)
+		return event, event.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// LeaveChat attempts to leave a chat room. The chat room is removed from Chats right away
 func (s *Social) LeaveChat(id steamid.SteamId) {
 	chatID := id.ClanToChat()
+	s.Chats.Remove(chatID)
+	self := s.client.SteamId().ToUint64()
 	payload := new(bytes.Buffer)
-	_ = binary.Write(payload, binary.LittleEndian, s.client.SteamId().ToUint64())       // ChatterActedOn
-	_ = binary.Write(payload, binary.LittleEndian, uint32(EChatMemberStateChange_Left)) // StateChange
-	_ = binary.Write(payload, binary.LittleEndian, s.client.SteamId().ToUint64())       // ChatterActedBy
+	_ = WriteUint64(payload, self)                                // ChatterActedOn
+	_ = WriteUint32(payload, uint32(EChatMemberStateChange_Left)) // StateChange
+	_ = WriteUint64(payload, self)                                // ChatterActedBy
 	s.client.Write(NewClientMsg(&MsgClientChatMemberInfo{
 		SteamIdChat: SteamId(chatID),
 		Type:        EChatInfoType_StateChange,
 	}, payload.Bytes()))
 }
 
+// LeaveAllChats leaves every joined chat room
+func (s *Social) LeaveAllChats() {
+	for _, chat := range s.Chats.GetJoined() {
+		s.LeaveChat(chat.SteamId)
+	}
+}
+
 // KickChatMember the specified chat member from the given chat room
 func (s *Social) KickChatMember(room steamid.SteamId, user SteamId) {
 	chatID := room.ClanToChat()
@@ -212,15 +1048,130 @@ func (s *Social) UnbanChatMember(room steamid.SteamId, user SteamId) {
 	}, make([]byte, 0)))
 }
 
+// Resync refreshes the social caches after a reconnect: it clears the cached presence of
+// all friends, requests their persona state again and rejoins all joined chat rooms.
+// Call it once logged on again, e.g. on a LoggedOnEvent following a DisconnectedEvent
+func (s *Social) Resync() {
+	s.Friends.ClearPresence()
+	var ids []steamid.SteamId
+	for id := range s.Friends.GetCopy() {
+		ids = append(ids, id)
+	}
+	if len(ids) > 0 {
+		s.RequestFriendListInfo(ids, EClientPersonaStateFlag_DefaultInfoRequest)
+	}
+	for _, chat := range s.Chats.GetJoined() {
+		s.JoinChat(chat.SteamId)
+	}
+}
+
+// OnChatMsg registers a handler for every ChatMsgEvent and returns a function that unregisters it.
+// Handlers are called from the packet handling goroutine before the event is emitted and must not block
+func (s *Social) OnChatMsg(handler func(*ChatMsgEvent)) (unsubscribe func()) {
+	return s.subscribers.add(func(event interface{}) {
+		if e, ok := event.(*ChatMsgEvent); ok {
+			handler(e)
+		}
+	})
+}
+
+// OnPersonaState registers a handler for every PersonaStateEvent and returns a function that unregisters it.
+// Handlers are called from the packet handling goroutine before the event is emitted and must not block
+func (s *Social) OnPersonaState(handler func(*PersonaStateEvent)) (unsubscribe func()) {
+	return s.subscribers.add(func(event interface{}) {
+		if e, ok := event.(*PersonaStateEvent); ok {
+			handler(e)
+		}
+	})
+}
+
+// SetEventFilter sets a predicate that every event emitted by Social must pass to reach the
+// subscribed handlers and the client's events channel. The caches are updated regardless.
+// A nil filter lets all events through
+func (s *Social) SetEventFilter(filter func(event interface{}) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eventFilter = filter
+}
+
+// emit passes an event to the subscribed handlers and emits it on the client,
+// unless it is dropped by the event filter
+func (s *Social) emit(event interface{}) {
+	s.mutex.RLock()
+	filter := s.eventFilter
+	s.mutex.RUnlock()
+	if filter != nil && !filter(event) {
+		return
+	}
+	s.subscribers.dispatch(event)
+	s.client.Emit(event)
+}
+
+// A handler registered with RegisterPacketHandler
+type customPacketHandler struct {
+	id int
+	fn func(*Packet)
+}
+
+// RegisterPacketHandler registers a function that is called with every incoming packet of the
+// given EMsg, which may be one Social doesn't handle itself. Handlers run after the built-in
+// handler, in the order they were registered, on the goroutine reading packets.
+// Returns a function that unregisters the handler
+func (s *Social) RegisterPacketHandler(emsg EMsg, fn func(*Packet)) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := s.nextHandlerId
+	s.nextHandlerId++
+	s.packetHandlers[emsg] = append(s.packetHandlers[emsg], customPacketHandler{id, fn})
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		handlers := s.packetHandlers[emsg]
+		for i, handler := range handlers {
+			if handler.id == id {
+				s.packetHandlers[emsg] = append(handlers[:i:i], handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
 // HandlePacket handles a Steam packet.
 func (s *Social) HandlePacket(packet *Packet) {
+	s.handleBuiltinPacket(packet)
+	s.mutex.RLock()
+	handlers := s.packetHandlers[packet.EMsg]
+	s.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler.fn(packet)
+	}
+}
+
+func (s *Social) handleBuiltinPacket(packet *Packet) {
+	if s.handleGroupInviteResult(packet) {
+		return
+	}
 	switch packet.EMsg {
 	case EMsg_ClientPersonaState:
 		s.handlePersonaState(packet)
+	case EMsg_ClientLogOnResponse:
+		s.handleLogOnResponse(packet)
+	case EMsg_ClientLoggedOff:
+		s.setLoggedOff()
+	case EMsg_ServiceMethod:
+		s.handleServiceMethod(packet)
+	case EMsg_ClientServiceMethodResponse:
+		s.handleServiceMethodResponse(packet)
 	case EMsg_ClientClanState:
 		s.handleClanState(packet)
 	case EMsg_ClientFriendsList:
 		s.handleFriendsList(packet)
+	case EMsg_ClientPersonaChangeResponse:
+		s.handlePersonaChangeResponse(packet)
+	case EMsg_ClientFriendsGroupsList:
+		s.handleFriendsGroupsList(packet)
+	case EMsg_ClientPlayerNicknameList:
+		s.handleNicknameList(packet)
 	case EMsg_ClientFriendMsgIncoming:
 		s.handleFriendMsg(packet)
 	case EMsg_ClientAccountInfo:
@@ -233,6 +1184,8 @@ func (s *Social) HandlePacket(packet *Packet) {
 		s.handleChatMsg(packet)
 	case EMsg_ClientChatMemberInfo:
 		s.handleChatMemberInfo(packet)
+	case EMsg_ClientChatRoomInfo:
+		s.handleChatRoomInfo(packet)
 	case EMsg_ClientChatActionResult:
 		s.handleChatActionResult(packet)
 	case EMsg_ClientChatInvite:
@@ -246,8 +1199,52 @@ func (s *Social) HandlePacket(packet *Packet) {
 	}
 }
 
+// emitParseError emits a ParseErrorEvent for a packet that couldn't be read
+func (s *Social) emitParseError(packet *Packet, err error) {
+	s.emit(&ParseErrorEvent{EMsg: packet.EMsg, Err: err})
+}
+
+// handleGroupInviteResult handles the response to an InviteToGroup request, which only
+// carries a result. Returns false if the packet isn't such a response
+func (s *Social) handleGroupInviteResult(packet *Packet) bool {
+	if packet.EMsg != EMsg_ClientInviteUserToClan && packet.EMsg != EMsg_GenericReply {
+		return false
+	}
+	s.mutex.Lock()
+	invite, ok := s.groupInvites[packet.TargetJobId]
+	delete(s.groupInvites, packet.TargetJobId)
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	var result EResult
+	reader := bytes.NewReader(packet.Data)
+	if packet.IsProto {
+		header := NewMsgHdrProtoBuf()
+		header.Deserialize(reader)
+		result = EResult(header.Proto.GetEresult())
+	} else {
+		NewExtendedClientMsgHdr().Deserialize(reader)
+		value, _ := ReadInt32(reader)
+		result = EResult(value)
+	}
+	s.emit(&GroupInviteResultEvent{
+		ClanId:    invite.clan,
+		InviteeId: invite.invitee,
+		Result:    result,
+	})
+	return true
+}
+
 func (s *Social) handleAccountInfo(packet *Packet) {
-	//Just fire the personainfo, Auth handles the callback
+	// Auth emits the AccountInfoEvent, only cache the persona name and fire the personainfo
+	body := new(CMsgClientAccountInfo)
+	packet.ReadProtoMsg(body)
+	if body.GetPersonaName() != "" {
+		s.mutex.Lock()
+		s.name = body.GetPersonaName()
+		s.mutex.Unlock()
+	}
 	flags := EClientPersonaStateFlag_PlayerName | EClientPersonaStateFlag_Presence | EClientPersonaStateFlag_SourceID
 	s.RequestFriendInfo(s.client.SteamId(), EClientPersonaStateFlag(flags))
 }
@@ -264,29 +1261,31 @@ func (s *Social) handleFriendsList(packet *Packet) {
 			rel := EClanRelationship(friend.GetEfriendrelationship())
 			if rel == EClanRelationship_None {
 				s.Groups.Remove(steamID)
+			} else if _, err := s.Groups.ById(steamID); err == nil {
+				s.Groups.SetRelationship(steamID, rel)
 			} else {
 				s.Groups.Add(socialcache.Group{
 					SteamId:      steamID,
 					Relationship: rel,
 				})
-
 			}
 			if list.GetBincremental() {
-				s.client.Emit(&GroupStateEvent{steamid.SteamId(steamID), rel})
+				s.emit(&GroupStateEvent{steamid.SteamId(steamID), rel})
 			}
 		} else {
 			rel := EFriendRelationship(friend.GetEfriendrelationship())
 			if rel == EFriendRelationship_None {
 				s.Friends.Remove(steamID)
+			} else if _, err := s.Friends.ById(steamID); err == nil {
+				s.Friends.SetRelationship(steamID, rel)
 			} else {
 				s.Friends.Add(socialcache.Friend{
 					SteamId:      steamID,
 					Relationship: rel,
 				})
-
 			}
 			if list.GetBincremental() {
-				s.client.Emit(&FriendStateEvent{steamID, rel})
+				s.emit(&FriendStateEvent{steamID, rel})
 			}
 		}
 		if !list.GetBincremental() {
@@ -294,9 +1293,108 @@ func (s *Social) handleFriendsList(packet *Packet) {
 		}
 	}
 	if !list.GetBincremental() {
+		s.removeMissing(friends)
 		s.RequestFriendListInfo(friends, EClientPersonaStateFlag_DefaultInfoRequest)
-		s.client.Emit(&FriendsListEvent{})
+		s.emit(&FriendsListEvent{})
+	}
+}
+
+// removeMissing removes all cached friends and groups that aren't part of a full friends list
+func (s *Social) removeMissing(ids []steamid.SteamId) {
+	present := make(map[steamid.SteamId]bool, len(ids))
+	for _, id := range ids {
+		present[id] = true
+	}
+	for id := range s.Friends.GetCopy() {
+		if !present[id] {
+			s.Friends.Remove(id)
+			s.emit(&FriendStateEvent{id, EFriendRelationship_None})
+		}
+	}
+	for id := range s.Groups.GetCopy() {
+		if !present[id] {
+			s.Groups.Remove(id)
+			s.emit(&GroupStateEvent{id, EClanRelationship_None})
+		}
+	}
+}
+
+func (s *Social) handlePersonaChangeResponse(packet *Packet) {
+	body := new(CMsgPersonaChangeResponse)
+	packet.ReadProtoMsg(body)
+	result := EResult(body.GetResult())
+	s.mutex.Lock()
+	name := body.GetPlayerName()
+	if name == "" {
+		name = s.pendingName
+	}
+	if result == EResult_OK {
+		s.name = name
+	}
+	s.pendingName = ""
+	s.mutex.Unlock()
+	s.emit(&PersonaNameChangeEvent{
+		Result: result,
+		Name:   name,
+	})
+}
+
+func (s *Social) handleFriendsGroupsList(packet *Packet) {
+	list := new(CMsgClientFriendsGroupsList)
+	packet.ReadProtoMsg(list)
+	if !list.GetBincremental() {
+		s.Categories.Clear()
 	}
+	for _, group := range list.GetFriendGroups() {
+		if list.GetBremoval() {
+			s.Categories.Remove(group.GetNGroupID())
+		} else {
+			s.Categories.Add(socialcache.Category{
+				Id:   group.GetNGroupID(),
+				Name: group.GetStrGroupName(),
+			})
+		}
+	}
+	for _, membership := range list.GetMemberships() {
+		id := steamid.SteamId(membership.GetUlSteamID())
+		if list.GetBremoval() {
+			s.Categories.RemoveMember(membership.GetNGroupID(), id)
+		} else {
+			s.Categories.AddMember(membership.GetNGroupID(), id)
+		}
+	}
+	s.emit(&FriendCategoriesEvent{
+		Incremental: list.GetBincremental(),
+		Removal:     list.GetBremoval(),
+	})
+}
+
+func (s *Social) handleNicknameList(packet *Packet) {
+	list := new(CMsgClientPlayerNicknameList)
+	packet.ReadProtoMsg(list)
+	nicknames := make(map[steamid.SteamId]string)
+	for _, nickname := range list.GetNicknames() {
+		id := steamid.SteamId(nickname.GetSteamid())
+		nicknames[id] = nickname.GetNickname()
+		if list.GetRemoval() {
+			s.Friends.SetNickname(id, "")
+		} else {
+			s.Friends.SetNickname(id, nickname.GetNickname())
+		}
+	}
+	if !list.GetIncremental() {
+		// The full list replaces all nicknames
+		for id, friend := range s.Friends.GetCopy() {
+			if _, ok := nicknames[id]; !ok && friend.Nickname != "" {
+				s.Friends.SetNickname(id, "")
+			}
+		}
+	}
+	s.emit(&NicknameListEvent{
+		Incremental: list.GetIncremental(),
+		Removal:     list.GetRemoval(),
+		Nicknames:   nicknames,
+	})
 }
 
 func (s *Social) handlePersonaState(packet *Packet) {
@@ -305,12 +1403,13 @@ func (s *Social) handlePersonaState(packet *Packet) {
 	flags := EClientPersonaStateFlag(list.GetStatusFlags())
 	for _, friend := range list.GetFriends() {
 		id := steamid.SteamId(friend.GetFriendid())
-		if id == s.client.SteamId() { //this is our client id
+		avatar := NormalizeAvatar(hex.EncodeToString(friend.GetAvatarHash()))
+		var gameChange *FriendGameChangeEvent
+		if id.Equal(s.client.SteamId()) { //this is our client id
 			s.mutex.Lock()
 			if friend.GetPlayerName() != "" {
 				s.name = friend.GetPlayerName()
 			}
-			avatar := hex.EncodeToString(friend.GetAvatarHash())
 			if ValidAvatar(avatar) {
 				s.avatar = avatar
 			}
@@ -319,21 +1418,47 @@ func (s *Social) handlePersonaState(packet *Packet) {
 			if (flags & EClientPersonaStateFlag_PlayerName) == EClientPersonaStateFlag_PlayerName {
 				if friend.GetPlayerName() != "" {
 					s.Friends.SetName(id, friend.GetPlayerName())
+					s.Chats.RenameMember(id, friend.GetPlayerName())
+					s.mutex.Lock()
+					delete(s.nameRequests, id)
+					s.mutex.Unlock()
 				}
 			}
 			if (flags & EClientPersonaStateFlag_Presence) == EClientPersonaStateFlag_Presence {
-				avatar := hex.EncodeToString(friend.GetAvatarHash())
 				if ValidAvatar(avatar) {
 					s.Friends.SetAvatar(id, avatar)
 				}
 				s.Friends.SetPersonaState(id, EPersonaState(friend.GetPersonaState()))
 				s.Friends.SetPersonaStateFlags(id, EPersonaStateFlag(friend.GetPersonaStateFlags()))
+				s.Friends.SetRichPresence(id, readRichPresence(friend.XXX_unrecognized))
+			}
+			if (flags & EClientPersonaStateFlag_SourceID) == EClientPersonaStateFlag_SourceID {
+				if friend.GetSteamidSource() != 0 {
+					s.Friends.SetSourceSteamId(id, steamid.SteamId(friend.GetSteamidSource()))
+				}
 			}
-			if (flags & EClientPersonaStateFlag_GameDataBlob) == EClientPersonaStateFlag_GameDataBlob {
+			// the game is sent with GameExtraInfo, which DefaultInfoRequest asks for
+			if flags&(EClientPersonaStateFlag_GameExtraInfo|EClientPersonaStateFlag_GameDataBlob) != 0 {
+				if cached, err := s.Friends.ById(id); err == nil && cached.GameAppId != friend.GetGamePlayedAppId() {
+					gameChange = &FriendGameChangeEvent{
+						FriendId: id,
+						OldAppId: cached.GameAppId,
+						NewAppId: friend.GetGamePlayedAppId(),
+						GameName: friend.GetGameName(),
+					}
+				}
 				s.Friends.SetGameAppId(id, friend.GetGamePlayedAppId())
 				s.Friends.SetGameId(id, friend.GetGameid())
 				s.Friends.SetGameName(id, friend.GetGameName())
 			}
+			if (flags & EClientPersonaStateFlag_LastSeen) == EClientPersonaStateFlag_LastSeen {
+				if friend.GetLastLogon() != 0 {
+					s.Friends.SetLastLogOn(id, time.Unix(int64(friend.GetLastLogon()), 0))
+				}
+				if friend.GetLastLogoff() != 0 {
+					s.Friends.SetLastLogOff(id, time.Unix(int64(friend.GetLastLogoff()), 0))
+				}
+			}
 		} else if id.GetAccountType() == EAccountType_Clan {
 			if (flags & EClientPersonaStateFlag_PlayerName) == EClientPersonaStateFlag_PlayerName {
 				if friend.GetPlayerName() != "" {
@@ -341,13 +1466,17 @@ func (s *Social) handlePersonaState(packet *Packet) {
 				}
 			}
 			if (flags & EClientPersonaStateFlag_Presence) == EClientPersonaStateFlag_Presence {
-				avatar := hex.EncodeToString(friend.GetAvatarHash())
 				if ValidAvatar(avatar) {
 					s.Groups.SetAvatar(id, avatar)
 				}
 			}
+			if (flags & EClientPersonaStateFlag_ClanTag) == EClientPersonaStateFlag_ClanTag {
+				if friend.GetClanTag() != "" {
+					s.Groups.SetClanTag(id, friend.GetClanTag())
+				}
+			}
 		}
-		s.client.Emit(&PersonaStateEvent{
+		event := &PersonaStateEvent{
 			StatusFlags:            flags,
 			FriendId:               id,
 			State:                  EPersonaState(friend.GetPersonaState()),
@@ -361,7 +1490,7 @@ func (s *Social) handlePersonaState(packet *Packet) {
 			SourceSteamId:          steamid.SteamId(friend.GetSteamidSource()),
 			GameDataBlob:           friend.GetGameDataBlob(),
 			Name:                   friend.GetPlayerName(),
-			Avatar:                 hex.EncodeToString(friend.GetAvatarHash()),
+			Avatar:                 avatar,
 			LastLogOff:             friend.GetLastLogoff(),
 			LastLogOn:              friend.GetLastLogon(),
 			ClanRank:               friend.GetClanRank(),
@@ -371,10 +1500,71 @@ func (s *Social) handlePersonaState(packet *Packet) {
 			PersonaSetByUser:       friend.GetPersonaSetByUser(),
 			FacebookName:           friend.GetFacebookName(),
 			FacebookId:             friend.GetFacebookId(),
-		})
+			IsSelf:                 id.Equal(s.client.SteamId()),
+		}
+		s.personaWaiters.notify(id, event)
+		s.emit(event)
+		if gameChange != nil {
+			s.emit(gameChange)
+		}
 	}
 }
 
+// The rich presence field of CMsgClientPersonaState.Friend, which is missing from the generated
+// protobuf code and therefore only available from its unrecognized fields:
+//
+//	message KV { optional string key = 1; optional string value = 2; }
+//	repeated KV rich_presence = 71;
+const personaStateRichPresenceField = 71
+
+// readRichPresence reads the rich presence key/values from the unrecognized fields of a persona state
+func readRichPresence(raw []byte) map[string]string {
+	richPresence := make(map[string]string)
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			break
+		}
+		raw = raw[n:]
+		if num != personaStateRichPresenceField || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, raw)
+			if n < 0 {
+				break
+			}
+			raw = raw[n:]
+			continue
+		}
+		kv, n := protowire.ConsumeBytes(raw)
+		if n < 0 {
+			break
+		}
+		raw = raw[n:]
+		var key, value string
+		for len(kv) > 0 {
+			num, typ, n := protowire.ConsumeTag(kv)
+			if n < 0 {
+				break
+			}
+			kv = kv[n:]
+			if typ != protowire.BytesType {
+				n = protowire.ConsumeFieldValue(num, typ, kv)
+			} else if num == 1 {
+				key, n = protowire.ConsumeString(kv)
+			} else {
+				value, n = protowire.ConsumeString(kv)
+			}
+			if n < 0 {
+				break
+			}
+			kv = kv[n:]
+		}
+		if key != "" {
+			richPresence[key] = value
+		}
+	}
+	return richPresence
+}
+
 func (s *Social) handleClanState(packet *Packet) {
 	body := new(CMsgClientClanState)
 	packet.ReadProtoMsg(body)
@@ -424,17 +1614,15 @@ func (s *Social) handleClanState(packet *Packet) {
 		}
 	}
 	if body.GetUserCounts() != nil {
-		s.Groups.SetMemberTotalCount(clanid, totalCount)
-		s.Groups.SetMemberOnlineCount(clanid, onlineCount)
-		s.Groups.SetMemberChattingCount(clanid, chattingCount)
-		s.Groups.SetMemberInGameCount(clanid, ingameCount)
+		s.Groups.SetMemberCounts(clanid, totalCount, onlineCount, chattingCount, ingameCount)
 	}
-	s.client.Emit(&ClanStateEvent{
+	s.emit(&ClanStateEvent{
 		ClandId:             clanid,
 		StateFlags:          EClientPersonaStateFlag(body.GetMUnStatusFlags()),
 		AccountFlags:        EAccountFlags(body.GetClanAccountFlags()),
 		ClanName:            name,
 		Avatar:              avatar,
+		HasCounts:           body.GetUserCounts() != nil,
 		MemberTotalCount:    totalCount,
 		MemberOnlineCount:   onlineCount,
 		MemberChattingCount: chattingCount,
@@ -442,12 +1630,22 @@ func (s *Social) handleClanState(packet *Packet) {
 		Events:              events,
 		Announcements:       announcements,
 	})
+	s.mutex.Lock()
+	requestedAt, requested := s.clanEventRequests[clanid]
+	delete(s.clanEventRequests, clanid)
+	s.mutex.Unlock()
+	if requested && time.Since(requestedAt) <= clanEventsTimeout {
+		s.emit(&ClanEventsEvent{
+			ClanId: clanid,
+			Events: events,
+		})
+	}
 }
 
 func (s *Social) handleFriendResponse(packet *Packet) {
 	body := new(CMsgClientAddFriendResponse)
 	packet.ReadProtoMsg(body)
-	s.client.Emit(&FriendAddedEvent{
+	s.emit(&FriendAddedEvent{
 		Result:      EResult(body.GetEresult()),
 		SteamId:     steamid.SteamId(body.GetSteamIdAdded()),
 		PersonaName: body.GetPersonaNameAdded(),
@@ -458,54 +1656,111 @@ func (s *Social) handleFriendMsg(packet *Packet) {
 	body := new(CMsgClientFriendMsgIncoming)
 	packet.ReadProtoMsg(body)
 	message := string(bytes.Split(body.GetMessage(), []byte{0x0})[0])
-	s.client.Emit(&ChatMsgEvent{
-		ChatterId: SteamId(body.GetSteamidFrom()),
-		Message:   message,
-		EntryType: EChatEntryType(body.GetChatEntryType()),
-		Timestamp: time.Unix(int64(body.GetRtime32ServerTimestamp()), 0),
-	})
+	entryType := EChatEntryType(body.GetChatEntryType())
+	event := &ChatMsgEvent{
+		ChatterId:       SteamId(body.GetSteamidFrom()),
+		Message:         message,
+		EntryType:       entryType,
+		Timestamp:       time.Now(),
+		ServerTimestamp: body.GetRtime32ServerTimestamp() != 0,
+	}
+	if event.ServerTimestamp {
+		event.Timestamp = time.Unix(int64(body.GetRtime32ServerTimestamp()), 0)
+	}
+	s.logMessage(steamid.SteamId(body.GetSteamidFrom()), event)
+	s.emit(event)
+	s.mutex.RLock()
+	autoAck := s.autoAck
+	s.mutex.RUnlock()
+	if autoAck && entryType == EChatEntryType_ChatMsg {
+		s.ackFriendMessage(steamid.SteamId(body.GetSteamidFrom()), body.GetRtime32ServerTimestamp())
+	}
 }
 
 func (s *Social) handleChatMsg(packet *Packet) {
 	body := new(MsgClientChatMsg)
 	payload := packet.ReadClientMsg(body).Payload
 	message := string(bytes.Split(payload, []byte{0x0})[0])
-	s.client.Emit(&ChatMsgEvent{
+	// room messages carry no server timestamp
+	event := &ChatMsgEvent{
 		ChatRoomId: SteamId(body.SteamIdChatRoom),
 		ChatterId:  SteamId(body.SteamIdChatter),
 		Message:    message,
 		EntryType:  EChatEntryType(body.ChatMsgType),
-	})
+		Timestamp:  time.Now(),
+	}
+	s.logMessage(steamid.SteamId(body.SteamIdChatRoom), event)
+	s.emit(event)
+	s.checkChatFlood(steamid.SteamId(body.SteamIdChatRoom), steamid.SteamId(body.SteamIdChatter))
+}
+
+// checkChatFlood counts a message of a chatter in a room and emits a ChatFloodEvent
+// once they exceed the flood threshold
+func (s *Social) checkChatFlood(room, chatter steamid.SteamId) {
+	s.mutex.RLock()
+	flood := s.chatFlood
+	s.mutex.RUnlock()
+	if flood == nil {
+		return
+	}
+	if count, flooding := flood.add(room, chatter); flooding {
+		s.emit(&ChatFloodEvent{ChatRoomId: room, ChatterId: chatter, Count: count})
+	}
 }
 
 func (s *Social) handleChatEnter(packet *Packet) {
 	body := new(MsgClientChatEnter)
 	payload := packet.ReadClientMsg(body).Payload
 	reader := bytes.NewBuffer(payload)
-	name, _ := ReadString(reader)
-	_, _ = ReadByte(reader) //0
+	name, err := ReadStringN(reader, maxChatStringLength)
+	if err == nil {
+		_, err = ReadByte(reader) //0
+	}
 	count := body.NumMembers
 	chatID := steamid.SteamId(body.SteamIdChat)
 	clanID := steamid.SteamId(body.SteamIdClan)
-	s.Chats.Add(socialcache.Chat{SteamId: chatID, GroupId: clanID})
-	for i := 0; i < int(count); i++ {
-		id, chatPerm, clanPerm := readChatMember(reader)
-		_, _ = ReadBytes(reader, 6) //No idea what this is
-		s.Chats.AddChatMember(chatID, socialcache.ChatMember{
-			SteamId:         steamid.SteamId(id),
-			ChatPermissions: chatPerm,
-			ClanPermissions: clanPerm,
-		})
+	// ad-hoc multi-user chats don't belong to a clan, while the chat room of a clan does
+	// even if the enter message leaves its clan out
+	if !chatID.IsChatClan() {
+		clanID = 0
+	} else if clanID == 0 {
+		clanID = chatID.ChatToClan()
 	}
-	s.client.Emit(&ChatEnterEvent{
+	var members []socialcache.ChatMember
+	for i := 0; i < int(count) && err == nil; i++ {
+		var member socialcache.ChatMember
+		member, err = readChatMember(reader)
+		members = append(members, member)
+		_, _ = ReadBytes(reader, 5) //No idea what this is
+	}
+	// failed enters may come without a name or members, only a successful one needs them
+	if err != nil && EChatRoomEnterResponse(body.EnterResponse) == EChatRoomEnterResponse_Success {
+		s.emitParseError(packet, err)
+		return
+	}
+	event := &ChatEnterEvent{
 		ChatRoomId:    steamid.SteamId(body.SteamIdChat),
 		FriendId:      steamid.SteamId(body.SteamIdFriend),
 		ChatRoomType:  EChatRoomType(body.ChatRoomType),
 		OwnerId:       steamid.SteamId(body.SteamIdOwner),
-		ClanId:        steamid.SteamId(body.SteamIdClan),
+		ClanId:        clanID,
 		ChatFlags:     byte(body.ChatFlags),
 		EnterResponse: EChatRoomEnterResponse(body.EnterResponse),
 		Name:          name,
+	}
+	s.chatEnterWaiters.notify(event.ChatRoomId, event)
+	if !event.Success() {
+		s.Chats.Remove(chatID)
+		s.emit(event)
+		return
+	}
+	s.Chats.Add(socialcache.Chat{SteamId: chatID, GroupId: clanID, Name: name, Joined: true})
+	// The enter message carries the full member list, so drop members left over from before
+	s.Chats.SetChatMembers(chatID, members)
+	s.emit(event)
+	s.emit(&ChatMembersRefreshedEvent{
+		ChatRoomId: chatID,
+		Members:    members,
 	})
 }
 
@@ -515,53 +1770,200 @@ func (s *Social) handleChatMemberInfo(packet *Packet) {
 	reader := bytes.NewBuffer(payload)
 	chatID := steamid.SteamId(body.SteamIdChat)
 	if body.Type == EChatInfoType_StateChange {
-		actedOn, _ := ReadUint64(reader)
-		state, _ := ReadInt32(reader)
-		actedBy, _ := ReadUint64(reader)
+		actedOn, err := ReadUint64(reader)
+		var state int32
+		if err == nil {
+			state, err = ReadInt32(reader)
+		}
+		var actedBy uint64
+		if err == nil {
+			actedBy, err = ReadUint64(reader)
+		}
+		if err != nil {
+			s.emitParseError(packet, err)
+			return
+		}
 		_, _ = ReadByte(reader) //0
 		stateChange := EChatMemberStateChange(state)
 		if stateChange == EChatMemberStateChange_Entered {
-			_, chatPerm, clanPerm := readChatMember(reader)
-			s.Chats.AddChatMember(chatID, socialcache.ChatMember{
-				SteamId:         steamid.SteamId(actedOn),
-				ChatPermissions: chatPerm,
-				ClanPermissions: clanPerm,
-			})
+			member, err := readChatMember(reader)
+			if err != nil {
+				s.emitParseError(packet, err)
+				return
+			}
+			member.SteamId = steamid.SteamId(actedOn)
+			s.Chats.AddChatMember(chatID, member)
 		} else if stateChange == EChatMemberStateChange_Banned || stateChange == EChatMemberStateChange_Kicked ||
 			stateChange == EChatMemberStateChange_Disconnected || stateChange == EChatMemberStateChange_Left {
-			s.Chats.RemoveChatMember(chatID, steamid.SteamId(actedOn))
+			if steamid.SteamId(actedOn).Equal(s.client.SteamId()) {
+				// we are no longer in the chat room, so its members aren't kept up to date either
+				s.Chats.Remove(chatID)
+			} else {
+				s.Chats.RemoveChatMember(chatID, steamid.SteamId(actedOn))
+			}
 		}
 		stateInfo := StateChangeDetails{
 			ChatterActedOn: SteamId(actedOn),
 			StateChange:    EChatMemberStateChange(stateChange),
 			ChatterActedBy: SteamId(actedBy),
 		}
-		s.client.Emit(&ChatMemberInfoEvent{
+		s.emit(&ChatMemberInfoEvent{
 			ChatRoomId:      steamid.SteamId(body.SteamIdChat),
 			Type:            EChatInfoType(body.Type),
 			StateChangeInfo: stateInfo,
 		})
+		if stateChange == EChatMemberStateChange_VoiceSpeaking || stateChange == EChatMemberStateChange_VoiceDoneSpeaking {
+			s.emit(&ChatMemberVoiceEvent{
+				ChatRoomId: chatID,
+				MemberId:   steamid.SteamId(actedOn),
+				Speaking:   stateChange == EChatMemberStateChange_VoiceSpeaking,
+			})
+		}
+	} else if body.Type == EChatInfoType_InfoUpdate {
+		// A member's permissions have changed, the payload is the updated member
+		member, err := readChatMember(reader)
+		if err != nil {
+			s.emitParseError(packet, err)
+			return
+		}
+		s.Chats.AddChatMember(chatID, member)
+		s.emit(&ChatMemberPermissionsEvent{
+			ChatRoomId:      chatID,
+			MemberId:        member.SteamId,
+			ChatPermissions: member.ChatPermissions,
+			ClanPermissions: member.ClanPermissions,
+		})
 	}
 }
 
-func readChatMember(r io.Reader) (SteamId, EChatPermission, EClanPermission) {
-	_, _ = ReadString(r) // MessageObject
-	_, _ = ReadByte(r)   // 7
-	_, _ = ReadString(r) //steamid
-	id, _ := ReadUint64(r)
-	_, _ = ReadByte(r)   // 2
-	_, _ = ReadString(r) //Permissions
-	chat, _ := ReadInt32(r)
-	_, _ = ReadByte(r)   // 2
-	_, _ = ReadString(r) //Details
-	clan, _ := ReadInt32(r)
-	return SteamId(id), EChatPermission(chat), EClanPermission(clan)
+func (s *Social) handleChatRoomInfo(packet *Packet) {
+	body := new(MsgClientChatRoomInfo)
+	payload := packet.ReadClientMsg(body).Payload
+	if body.Type != EChatInfoType_InfoUpdate {
+		return
+	}
+	reader := bytes.NewBuffer(payload)
+	chatFlags, err := ReadUint32(reader)
+	if err != nil {
+		s.emitParseError(packet, err)
+		return
+	}
+	changedBy, err := ReadUint64(reader)
+	if err != nil {
+		s.emitParseError(packet, err)
+		return
+	}
+	name, err := ReadStringN(reader, maxChatStringLength) //Only sent when the room has been renamed
+	if err != nil && err != io.EOF {
+		s.emitParseError(packet, err)
+		return
+	}
+	chatID := steamid.SteamId(body.SteamIdChat)
+	if name != "" {
+		s.Chats.SetName(chatID, name)
+	}
+	s.emit(&ChatRoomInfoEvent{
+		ChatRoomId: chatID,
+		Type:       EChatInfoType(body.Type),
+		ChatFlags:  EChatFlags(chatFlags),
+		ChangedBy:  steamid.SteamId(changedBy),
+		Name:       name,
+	})
+}
+
+// The longest string read from chat packets, so corrupt packets can't cause large allocations
+const maxChatStringLength = 4096
+
+// readChatMember reads a chat member's MessageObject up to and including its end marker
+func readChatMember(r io.Reader) (socialcache.ChatMember, error) {
+	var member socialcache.ChatMember
+	if _, err := ReadStringN(r, maxChatStringLength); err != nil { // MessageObject
+		return member, err
+	}
+	for {
+		kvType, err := ReadByte(r)
+		if err != nil {
+			return member, err
+		}
+		if kvType == KeyValuesEnd {
+			return member, nil
+		}
+		key, err := ReadStringN(r, maxChatStringLength)
+		if err != nil {
+			return member, err
+		}
+		switch kvType {
+		case KeyValuesUint64:
+			var value uint64
+			value, err = ReadUint64(r)
+			if key == "steamid" {
+				member.SteamId = steamid.SteamId(value)
+			}
+		case KeyValuesInt32, KeyValuesPointer, KeyValuesColor:
+			var value int32
+			value, err = ReadInt32(r)
+			if key == "Permissions" {
+				member.ChatPermissions = EChatPermission(value)
+			} else if key == "Details" {
+				member.ClanPermissions = EClanPermission(value)
+			}
+		case KeyValuesString:
+			var value string
+			value, err = ReadStringN(r, maxChatStringLength)
+			if strings.EqualFold(key, "name") || strings.EqualFold(key, "PersonaName") {
+				member.Name = value
+			}
+		case KeyValuesFloat32:
+			_, err = ReadBytes(r, 4)
+		case KeyValuesInt64:
+			_, err = ReadBytes(r, 8)
+		case KeyValuesNone:
+			err = skipKeyValues(r)
+		default:
+			return member, fmt.Errorf("Unknown KeyValues type %d of %q", kvType, key)
+		}
+		if err != nil {
+			return member, err
+		}
+	}
+}
+
+// skipKeyValues skips the children of a binary KeyValues subsection and its end marker
+func skipKeyValues(r io.Reader) error {
+	for {
+		kvType, err := ReadByte(r)
+		if err != nil {
+			return err
+		}
+		if kvType == KeyValuesEnd {
+			return nil
+		}
+		key, err := ReadStringN(r, maxChatStringLength)
+		if err != nil {
+			return err
+		}
+		switch kvType {
+		case KeyValuesString:
+			_, err = ReadStringN(r, maxChatStringLength)
+		case KeyValuesInt32, KeyValuesPointer, KeyValuesColor, KeyValuesFloat32:
+			_, err = ReadBytes(r, 4)
+		case KeyValuesUint64, KeyValuesInt64:
+			_, err = ReadBytes(r, 8)
+		case KeyValuesNone:
+			err = skipKeyValues(r)
+		default:
+			return fmt.Errorf("Unknown KeyValues type %d of %q", kvType, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (s *Social) handleChatActionResult(packet *Packet) {
 	body := new(MsgClientChatActionResult)
 	packet.ReadClientMsg(body)
-	s.client.Emit(&ChatActionResultEvent{
+	s.emit(&ChatActionResultEvent{
 		ChatRoomId: SteamId(body.SteamIdChat),
 		ChatterId:  SteamId(body.SteamIdUserActedOn),
 		Action:     EChatAction(body.ChatAction),
@@ -572,7 +1974,7 @@ func (s *Social) handleChatActionResult(packet *Packet) {
 func (s *Social) handleChatInvite(packet *Packet) {
 	body := new(CMsgClientChatInvite)
 	packet.ReadProtoMsg(body)
-	s.client.Emit(&ChatInviteEvent{
+	s.emit(&ChatInviteEvent{
 		InvitedId:    steamid.SteamId(body.GetSteamIdInvited()),
 		ChatRoomId:   steamid.SteamId(body.GetSteamIdChat()),
 		PatronId:     steamid.SteamId(body.GetSteamIdPatron()),
@@ -586,7 +1988,7 @@ func (s *Social) handleChatInvite(packet *Packet) {
 func (s *Social) handleIgnoreFriendResponse(packet *Packet) {
 	body := new(MsgClientSetIgnoreFriendResponse)
 	packet.ReadClientMsg(body)
-	s.client.Emit(&IgnoreFriendEvent{
+	s.emit(&IgnoreFriendEvent{
 		Result: EResult(body.Result),
 	})
 }
@@ -594,7 +1996,7 @@ func (s *Social) handleIgnoreFriendResponse(packet *Packet) {
 func (s *Social) handleProfileInfoResponse(packet *Packet) {
 	body := new(CMsgClientFriendProfileInfoResponse)
 	packet.ReadProtoMsg(body)
-	s.client.Emit(&ProfileInfoEvent{
+	event := &ProfileInfoEvent{
 		Result:      EResult(body.GetEresult()),
 		SteamId:     steamid.SteamId(body.GetSteamidFriend()),
 		TimeCreated: body.GetTimeCreated(),
@@ -604,7 +2006,115 @@ func (s *Social) handleProfileInfoResponse(packet *Packet) {
 		CountryName: body.GetCountryName(),
 		Headline:    body.GetHeadline(),
 		Summary:     body.GetSummary(),
-	})
+	}
+	if event.Result == EResult_OK {
+		s.profileCache.add(event)
+	}
+	s.profileWaiters.notify(event.SteamId, event)
+	s.emit(event)
+}
+
+// responseWaiters correlates responses with pending blocking requests by SteamId
+type responseWaiters struct {
+	mutex  sync.Mutex
+	byId   map[steamid.SteamId][]chan interface{}
+	closed error
+}
+
+func newResponseWaiters() *responseWaiters {
+	return &responseWaiters{byId: make(map[steamid.SteamId][]chan interface{})}
+}
+
+// add registers a waiter for a response about the given SteamId
+func (w *responseWaiters) add(id steamid.SteamId) chan interface{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	ch := make(chan interface{}, 1)
+	if w.closed != nil {
+		ch <- w.closed
+		return ch
+	}
+	w.byId[id] = append(w.byId[id], ch)
+	return ch
+}
+
+// remove unregisters a waiter, it is safe to call after it has been notified
+func (w *responseWaiters) remove(id steamid.SteamId, ch chan interface{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	waiters := w.byId[id]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(w.byId, id)
+	} else {
+		w.byId[id] = waiters
+	}
+}
+
+// notify passes the response to every waiter for the given SteamId and unregisters them
+func (w *responseWaiters) notify(id steamid.SteamId, response interface{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, ch := range w.byId[id] {
+		ch <- response
+	}
+	delete(w.byId, id)
+}
+
+// close passes err to all current and future waiters
+func (w *responseWaiters) close(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = err
+	for id, waiters := range w.byId {
+		for _, ch := range waiters {
+			ch <- err
+		}
+		delete(w.byId, id)
+	}
+}
+
+// eventSubscribers holds the handlers registered with the On* methods of Social
+type eventSubscribers struct {
+	mutex  sync.RWMutex
+	nextId int
+	byId   map[int]func(interface{})
+}
+
+func newEventSubscribers() *eventSubscribers {
+	return &eventSubscribers{byId: make(map[int]func(interface{}))}
+}
+
+// add registers a handler and returns a function that unregisters it
+func (e *eventSubscribers) add(handler func(interface{})) func() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	id := e.nextId
+	e.nextId++
+	e.byId[id] = handler
+	return func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		delete(e.byId, id)
+	}
+}
+
+// dispatch passes an event to every registered handler
+func (e *eventSubscribers) dispatch(event interface{}) {
+	e.mutex.RLock()
+	handlers := make([]func(interface{}), 0, len(e.byId))
+	for _, handler := range e.byId {
+		handlers = append(handlers, handler)
+	}
+	e.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
 }
 
 /*
@@ -616,7 +2126,7 @@ func (s *Social) handleFriendMessageHistoryResponse(packet *Packet) {
 		if !message.GetUnread() {
 			continue // Skip already read messages
 		}
-		s.client.Emit(&ChatMsgEvent{
+		s.emit(&ChatMsgEvent{
 			ChatterId: steamid,
 			Message:   message.GetMessage(),
 			EntryType: EChatEntryType_ChatMsg,
@@ -1,13 +1,32 @@
 package steam
 
 import (
+	"fmt"
+	"github.com/anovokreschenov/go-steam/netutil"
 	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/socialcache"
 	"github.com/anovokreschenov/go-steam/steamid"
+	"net"
 	"time"
 )
 
 type FriendsListEvent struct{}
 
+// Fired when the friend categories have been received or changed. The categories
+// themselves can be read from Social.Categories
+type FriendCategoriesEvent struct {
+	Incremental bool
+	Removal     bool
+}
+
+// Fired when the nicknames given to friends have been received or changed. The nicknames
+// are cached in Social.Friends
+type NicknameListEvent struct {
+	Incremental bool
+	Removal     bool
+	Nicknames   map[steamid.SteamId]string
+}
+
 type FriendStateEvent struct {
 	SteamId      steamid.SteamId `json:",string"`
 	Relationship EFriendRelationship
@@ -26,6 +45,13 @@ func (g *GroupStateEvent) IsMember() bool {
 	return g.Relationship == EClanRelationship_Member
 }
 
+// Fired in response to inviting a user to a group with InviteToGroup
+type GroupInviteResultEvent struct {
+	ClanId    steamid.SteamId `json:",string"`
+	InviteeId steamid.SteamId `json:",string"`
+	Result    EResult
+}
+
 // Fired when someone changing their friend details
 type PersonaStateEvent struct {
 	StatusFlags            EClientPersonaStateFlag
@@ -51,6 +77,46 @@ type PersonaStateEvent struct {
 	PersonaSetByUser       bool
 	FacebookName           string
 	FacebookId             uint64 `json:",string"`
+	IsSelf                 bool   // whether this is the local user's own persona
+}
+
+// GameServerAddr returns the address of the game server the friend is playing on
+// as "a.b.c.d:port", or an empty string if they aren't on a server
+func (p *PersonaStateEvent) GameServerAddr() string {
+	if p.GameServerIp == 0 {
+		return ""
+	}
+	ip := p.GameServerIp
+	addr := &netutil.PortAddr{
+		IP:   net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)),
+		Port: uint16(p.GameServerPort),
+	}
+	return addr.String()
+}
+
+// Fired in response to changing the local user's persona name
+type PersonaNameChangeEvent struct {
+	Result EResult
+	Name   string
+}
+
+// Fired after a PersonaStateEvent when a cached friend starts or stops playing a game,
+// or switches games. An app id of 0 means not playing
+type FriendGameChangeEvent struct {
+	FriendId steamid.SteamId `json:",string"`
+	OldAppId uint32
+	NewAppId uint32
+	GameName string
+}
+
+// Whether the friend started playing
+func (f *FriendGameChangeEvent) Started() bool {
+	return f.OldAppId == 0
+}
+
+// Whether the friend stopped playing
+func (f *FriendGameChangeEvent) Stopped() bool {
+	return f.NewAppId == 0
 }
 
 // Fired when a clan's state has been changed
@@ -60,6 +126,7 @@ type ClanStateEvent struct {
 	AccountFlags        EAccountFlags
 	ClanName            string
 	Avatar              string
+	HasCounts           bool // whether the member counts were sent, they are zero otherwise
 	MemberTotalCount    uint32
 	MemberOnlineCount   uint32
 	MemberChattingCount uint32
@@ -68,6 +135,12 @@ type ClanStateEvent struct {
 	Announcements       []ClanEventDetails
 }
 
+// Fired in response to requesting a clan's events
+type ClanEventsEvent struct {
+	ClanId steamid.SteamId `json:",string"`
+	Events []ClanEventDetails
+}
+
 type ClanEventDetails struct {
 	Id         uint64 `json:",string"`
 	EventTime  uint32
@@ -83,14 +156,34 @@ type FriendAddedEvent struct {
 	PersonaName string
 }
 
+// Fired once a message sent with SendMessage has been written to the connection.
+// This doesn't mean the server has received it
+type MessageSentEvent struct {
+	Id          uint64          // the local message id returned by SendMessageWithId
+	RecipientId steamid.SteamId `json:",string"`
+	EntryType   EChatEntryType
+	Message     string
+}
+
+// Fired when a chat member exceeds the threshold set with SetChatFloodThreshold.
+// It is fired again only after the member's message count has dropped back to the threshold
+type ChatFloodEvent struct {
+	ChatRoomId steamid.SteamId `json:",string"`
+	ChatterId  steamid.SteamId `json:",string"`
+	Count      int             // the number of messages within the window
+}
+
 // Fired when the client receives a message from either a friend or a chat room
 type ChatMsgEvent struct {
-	ChatRoomId SteamId `json:",string"` // not set for friend messages
-	ChatterId  SteamId `json:",string"`
-	Message    string
-	EntryType  EChatEntryType
-	Timestamp  time.Time
-	Offline    bool
+	ChatRoomId      SteamId `json:",string"` // not set for friend messages
+	ChatterId       SteamId `json:",string"`
+	Message         string
+	EntryType       EChatEntryType
+	Timestamp       time.Time
+	Offline         bool
+	ServerTimestamp bool    // whether Timestamp was sent by the server rather than set on receipt
+	Outgoing        bool    // whether the message was sent by us, see Social.EmitSentMessages
+	RecipientId     SteamId `json:",string"` // only set for outgoing friend messages
 }
 
 // Whether the type is ChatMsg
@@ -110,6 +203,42 @@ type ChatEnterEvent struct {
 	Name          string
 }
 
+// Whether the chat room is locked
+func (c *ChatEnterEvent) IsLocked() bool {
+	return EChatFlags(c.ChatFlags)&EChatFlags_Locked != 0
+}
+
+// Whether only clan officers can chat, which is how a locked clan chat room behaves.
+// There is no separate flag for this, so it's always false for rooms without a clan
+func (c *ChatEnterEvent) IsOfficersOnly() bool {
+	return c.IsLocked() && c.ClanId != 0
+}
+
+// Whether the chat room is moderated
+func (c *ChatEnterEvent) IsModerated() bool {
+	return EChatFlags(c.ChatFlags)&EChatFlags_Moderated != 0
+}
+
+// Whether the chat room was entered successfully
+func (c *ChatEnterEvent) Success() bool {
+	return c.EnterResponse == EChatRoomEnterResponse_Success
+}
+
+// Err returns nil if the chat room was entered successfully, or an error describing the failure otherwise
+func (c *ChatEnterEvent) Err() error {
+	if c.Success() {
+		return nil
+	}
+	return fmt.Errorf("Entering chat room %v failed: %v", c.ChatRoomId, c.EnterResponse)
+}
+
+// Fired after entering a chat room, when the cached members of the room have been replaced
+// with the full member list sent by the server
+type ChatMembersRefreshedEvent struct {
+	ChatRoomId steamid.SteamId `json:",string"`
+	Members    []socialcache.ChatMember
+}
+
 // Fired in response to a chat member's info being received
 type ChatMemberInfoEvent struct {
 	ChatRoomId      steamid.SteamId `json:",string"`
@@ -117,12 +246,36 @@ type ChatMemberInfoEvent struct {
 	StateChangeInfo StateChangeDetails
 }
 
+// Fired when the permissions of a chat member have changed
+type ChatMemberPermissionsEvent struct {
+	ChatRoomId      steamid.SteamId `json:",string"`
+	MemberId        steamid.SteamId `json:",string"`
+	ChatPermissions EChatPermission
+	ClanPermissions EClanPermission
+}
+
+// Fired when a chat member starts or stops speaking in the room's voice chat
+type ChatMemberVoiceEvent struct {
+	ChatRoomId steamid.SteamId `json:",string"`
+	MemberId   steamid.SteamId `json:",string"`
+	Speaking   bool
+}
+
 type StateChangeDetails struct {
 	ChatterActedOn SteamId `json:",string"`
 	StateChange    EChatMemberStateChange
 	ChatterActedBy SteamId `json:",string"`
 }
 
+// Fired when a chat room's name or settings have changed
+type ChatRoomInfoEvent struct {
+	ChatRoomId steamid.SteamId `json:",string"`
+	Type       EChatInfoType
+	ChatFlags  EChatFlags
+	ChangedBy  steamid.SteamId `json:",string"`
+	Name       string          // empty if the name didn't change
+}
+
 // Fired when a chat action has completed
 type ChatActionResultEvent struct {
 	ChatRoomId SteamId `json:",string"`
@@ -131,6 +284,14 @@ type ChatActionResultEvent struct {
 	Result     EChatActionResult
 }
 
+// Err returns nil if the action succeeded, or an error describing the failed action otherwise
+func (c *ChatActionResultEvent) Err() error {
+	if c.Result == EChatActionResult_Success {
+		return nil
+	}
+	return fmt.Errorf("Chat action %v on %v in %v failed: %v", c.Action, c.ChatterId, c.ChatRoomId, c.Result)
+}
+
 // Fired when a chat invite is received
 type ChatInviteEvent struct {
 	InvitedId    steamid.SteamId `json:",string"`
@@ -159,3 +320,22 @@ type ProfileInfoEvent struct {
 	Headline    string
 	Summary     string
 }
+
+// Whether the profile couldn't be read because it is private. The response carries no
+// privacy fields, Steam denies access to the profile instead
+func (p *ProfileInfoEvent) IsPrivate() bool {
+	return p.Result == EResult_AccessDenied
+}
+
+// Fired when an incoming message is malformed, e.g. truncated. The message is dropped
+// without updating the caches
+type ParseErrorEvent struct {
+	EMsg EMsg
+	Err  error
+}
+
+// Fired in response to RequestChatRoomGroups with the chat room groups of the new chat
+// system we are in. Social.ChatRoomGroups is replaced with these groups
+type ChatRoomGroupsEvent struct {
+	Groups []socialcache.ChatRoomGroup
+}
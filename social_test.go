@@ -0,0 +1,2871 @@
+package steam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/anovokreschenov/go-steam/protocol"
+	. "github.com/anovokreschenov/go-steam/protocol/protobuf"
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	. "github.com/anovokreschenov/go-steam/rwu"
+	"github.com/anovokreschenov/go-steam/socialcache"
+	"github.com/anovokreschenov/go-steam/steamid"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeConnection is a connection that never sends or receives anything,
+// used so that Client.Write queues messages without a network.
+type fakeConnection struct{}
+
+func (c *fakeConnection) Read() (*Packet, error)  { select {} }
+func (c *fakeConnection) Write([]byte) error      { return nil }
+func (c *fakeConnection) Close() error            { return nil }
+func (c *fakeConnection) SetEncryptionKey([]byte) {}
+func (c *fakeConnection) IsEncrypted() bool       { return true }
+
+var testSelfId = steamid.NewIdAdv(1, 1, int32(EUniverse_Public), EAccountType_Individual)
+
+// newTestClient returns a client that appears connected and collects outgoing messages
+func newTestClient() *Client {
+	client := NewClient()
+	client.conn = &fakeConnection{}
+	client.writeChan = make(chan IMsg, 64)
+	atomic.StoreUint64(&client.steamId, testSelfId.ToUint64())
+	return client
+}
+
+// nextWritten returns the next queued outgoing message
+func nextWritten(t *testing.T, client *Client) IMsg {
+	t.Helper()
+	select {
+	case msg := <-client.writeChan:
+		return msg
+	default:
+		t.Fatal("Expected an outgoing message")
+		return nil
+	}
+}
+
+// nextEvent returns the next emitted event
+func nextEvent(t *testing.T, client *Client) interface{} {
+	t.Helper()
+	select {
+	case event := <-client.events:
+		return event
+	default:
+		t.Fatal("Expected an event")
+		return nil
+	}
+}
+
+// toPacket serializes a message and reads it back as an incoming packet
+func toPacket(t *testing.T, msg IMsg) *Packet {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := msg.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	packet, err := NewPacket(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func newProtoPacket(t *testing.T, eMsg EMsg, body proto.Message) *Packet {
+	t.Helper()
+	return toPacket(t, NewClientMsgProtobuf(eMsg, body))
+}
+
+func TestAddFriendByName(t *testing.T) {
+	client := newTestClient()
+	client.Social.AddFriendByName("gaben")
+
+	msg := nextWritten(t, client)
+	if msg.GetMsgType() != EMsg_ClientAddFriend {
+		t.Fatalf("Unexpected message type %v", msg.GetMsgType())
+	}
+	body := new(CMsgClientAddFriend)
+	toPacket(t, msg).ReadProtoMsg(body)
+	if body.GetAccountnameOrEmailToAdd() != "gaben" {
+		t.Fatalf("Expected name gaben, got %q", body.GetAccountnameOrEmailToAdd())
+	}
+	if body.SteamidToAdd != nil {
+		t.Fatalf("Expected no SteamId, got %v", body.GetSteamidToAdd())
+	}
+
+	added := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientAddFriendResponse, &CMsgClientAddFriendResponse{
+		Eresult:          proto.Int32(int32(EResult_OK)),
+		SteamIdAdded:     proto.Uint64(added.ToUint64()),
+		PersonaNameAdded: proto.String("Gabe"),
+	}))
+	event, ok := nextEvent(t, client).(*FriendAddedEvent)
+	if !ok {
+		t.Fatal("Expected a FriendAddedEvent")
+	}
+	if event.SteamId != added || event.PersonaName != "Gabe" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+}
+
+func TestPersonaStateSelf(t *testing.T) {
+	client := newTestClient()
+	other := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(testSelfId.ToUint64()), PlayerName: proto.String("me")},
+			{Friendid: proto.Uint64(other.ToUint64()), PlayerName: proto.String("someone")},
+		},
+	}))
+
+	self := nextEvent(t, client).(*PersonaStateEvent)
+	if !self.IsSelf || self.FriendId != testSelfId {
+		t.Fatalf("Expected a self persona state, got %+v", self)
+	}
+	if client.Social.GetPersonaName() != "me" {
+		t.Fatalf("Expected persona name me, got %q", client.Social.GetPersonaName())
+	}
+	friend := nextEvent(t, client).(*PersonaStateEvent)
+	if friend.IsSelf {
+		t.Fatal("Expected IsSelf to be false for a friend")
+	}
+}
+
+func TestPersonaStateSelfZeroAvatar(t *testing.T) {
+	client := newTestClient()
+	selfAvatar := func(hash []byte) {
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+			StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_Presence)),
+			Friends: []*CMsgClientPersonaState_Friend{
+				{Friendid: proto.Uint64(testSelfId.ToUint64()), AvatarHash: hash},
+			},
+		}))
+		nextEvent(t, client)
+	}
+
+	selfAvatar(make([]byte, 20))
+	if avatar := client.Social.GetAvatar(); avatar != "" {
+		t.Fatalf("Expected no avatar for a zero hash, got %q", avatar)
+	}
+	if url := AvatarURL(client.Social.GetAvatar()); url != AvatarURL(DefaultAvatar) {
+		t.Fatalf("Expected the default avatar URL, got %q", url)
+	}
+
+	hash, _ := hex.DecodeString(DefaultAvatar)
+	selfAvatar(hash)
+	selfAvatar(make([]byte, 20))
+	if avatar := client.Social.GetAvatar(); avatar != DefaultAvatar {
+		t.Fatalf("Expected avatar %q to be kept, got %q", DefaultAvatar, avatar)
+	}
+}
+
+func TestRequestFriendListInfoBatches(t *testing.T) {
+	client := newTestClient()
+	var ids []steamid.SteamId
+	for i := 0; i < 250; i++ {
+		ids = append(ids, steamid.NewIdAdv(uint32(i+2), 1, int32(EUniverse_Public), EAccountType_Individual))
+	}
+	client.Social.RequestFriendListInfo(ids, EClientPersonaStateFlag_DefaultInfoRequest)
+
+	var sizes []int
+	for len(client.writeChan) > 0 {
+		body := new(CMsgClientRequestFriendData)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		sizes = append(sizes, len(body.GetFriends()))
+	}
+	if len(sizes) != 3 || sizes[0] != 100 || sizes[1] != 100 || sizes[2] != 50 {
+		t.Fatalf("Expected batches of 100, 100 and 50, got %v", sizes)
+	}
+
+	client.Social.RequestFriendInfo(ids[0], EClientPersonaStateFlag_DefaultInfoRequest)
+	nextWritten(t, client)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected a single message for a single id")
+	}
+}
+
+func TestRequestFriendListInfoDuplicates(t *testing.T) {
+	client := newTestClient()
+	a, b := steamid.NewIndividual(2), steamid.NewIndividual(3)
+	client.Social.RequestFriendListInfo([]steamid.SteamId{a, testSelfId, b, a, testSelfId, testSelfId}, EClientPersonaStateFlag_PlayerName)
+
+	body := new(CMsgClientRequestFriendData)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	friends := body.GetFriends()
+	if len(friends) != 3 || steamid.SteamId(friends[0]) != a || steamid.SteamId(friends[1]) != testSelfId || steamid.SteamId(friends[2]) != b {
+		t.Fatalf("Expected unique ids in their original order, got %v", friends)
+	}
+}
+
+func TestChatRoomInfo(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewIdAdv(3, 0, int32(EUniverse_Public), EAccountType_Chat)
+	client.Social.Chats.Add(socialcache.Chat{SteamId: chatId, Name: "old"})
+
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, uint32(EChatFlags_Locked))
+	binary.Write(payload, binary.LittleEndian, testSelfId.ToUint64())
+	payload.WriteString("new\x00")
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatRoomInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_InfoUpdate,
+	}, payload.Bytes())))
+
+	event := nextEvent(t, client).(*ChatRoomInfoEvent)
+	if event.ChatRoomId != chatId || event.Name != "new" || event.ChatFlags != EChatFlags_Locked || event.ChangedBy != testSelfId {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	chat, err := client.Social.Chats.ById(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.Name != "new" {
+		t.Fatalf("Expected cached name new, got %q", chat.Name)
+	}
+}
+
+func TestAcceptDeclineFriendRequest(t *testing.T) {
+	client := newTestClient()
+	pending := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	friend := steamid.NewIdAdv(3, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: pending, Relationship: EFriendRelationship_RequestRecipient})
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Relationship: EFriendRelationship_Friend})
+
+	if err := client.Social.AcceptFriendRequest(pending); err != nil {
+		t.Fatal(err)
+	}
+	msg := nextWritten(t, client)
+	body := new(CMsgClientAddFriend)
+	toPacket(t, msg).ReadProtoMsg(body)
+	if msg.GetMsgType() != EMsg_ClientAddFriend || steamid.SteamId(body.GetSteamidToAdd()) != pending {
+		t.Fatalf("Expected an add friend message for %v", pending)
+	}
+
+	if err := client.Social.DeclineFriendRequest(friend); err == nil {
+		t.Fatal("Expected an error declining a friend without a pending request")
+	}
+	unknown := steamid.NewIdAdv(4, 1, int32(EUniverse_Public), EAccountType_Individual)
+	if err := client.Social.DeclineFriendRequest(unknown); err == nil {
+		t.Fatal("Expected an error declining an unknown id")
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no message to be sent for invalid requests")
+	}
+}
+
+func TestPersonaStateKeepsAvatar(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Avatar: DefaultAvatar})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_Presence)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(friend.ToUint64()), AvatarHash: make([]byte, 20)},
+		},
+	}))
+	nextEvent(t, client)
+
+	cached, err := client.Social.Friends.ById(friend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Avatar != DefaultAvatar {
+		t.Fatalf("Expected avatar to be kept, got %q", cached.Avatar)
+	}
+}
+
+func TestRequestAllGroupInfo(t *testing.T) {
+	client := newTestClient()
+	groups := map[steamid.SteamId]bool{}
+	for i := uint32(1); i <= 3; i++ {
+		id := steamid.NewIdAdv(i, 0, int32(EUniverse_Public), EAccountType_Clan)
+		groups[id] = true
+		client.Social.Groups.Add(socialcache.Group{SteamId: id, Relationship: EClanRelationship_Member})
+	}
+	client.Social.RequestAllGroupInfo()
+
+	if len(client.writeChan) != len(groups) {
+		t.Fatalf("Expected %d requests, got %d", len(groups), len(client.writeChan))
+	}
+	for len(client.writeChan) > 0 {
+		body := new(CMsgClientRequestFriendData)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		if len(body.GetFriends()) != 1 || !groups[steamid.SteamId(body.GetFriends()[0])] {
+			t.Fatalf("Unexpected request for %v", body.GetFriends())
+		}
+		delete(groups, steamid.SteamId(body.GetFriends()[0]))
+	}
+}
+
+func TestRequestClanState(t *testing.T) {
+	client := newTestClient()
+	if err := client.Social.RequestClanState(steamid.NewIndividual(2)); err == nil {
+		t.Fatal("Expected an error for a non-clan SteamId")
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no request for a non-clan SteamId")
+	}
+
+	clan := steamid.NewClan(4)
+	if err := client.Social.RequestClanState(clan.ClanToChat()); err != nil {
+		t.Fatal(err)
+	}
+	request := new(CMsgClientRequestFriendData)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(request)
+	if len(request.GetFriends()) != 1 || steamid.SteamId(request.GetFriends()[0]) != clan {
+		t.Fatalf("Expected a request for %v, got %v", clan, request.GetFriends())
+	}
+	if EClientPersonaStateFlag(request.GetPersonaStateRequested()) != clanStateFlags {
+		t.Fatalf("Expected flags %v, got %v", clanStateFlags, EClientPersonaStateFlag(request.GetPersonaStateRequested()))
+	}
+}
+
+func TestChatActionResultErr(t *testing.T) {
+	event := &ChatActionResultEvent{Action: EChatAction_Kick, Result: EChatActionResult_Success}
+	if err := event.Err(); err != nil {
+		t.Fatalf("Expected no error on success, got %v", err)
+	}
+	for _, result := range []EChatActionResult{EChatActionResult_NotPermitted, EChatActionResult_Error, EChatActionResult_NotAllowedOnChatOwner} {
+		event := &ChatActionResultEvent{Action: EChatAction_Ban, Result: result}
+		err := event.Err()
+		if err == nil {
+			t.Fatalf("Expected an error for %v", result)
+		}
+		if !strings.Contains(err.Error(), result.String()) || !strings.Contains(err.Error(), EChatAction_Ban.String()) {
+			t.Fatalf("Expected the error to name the action and result, got %q", err)
+		}
+	}
+}
+
+func TestSendGameInvite(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	if err := client.Social.SendGameInvite(friend, "+connect 127.0.0.1:27015"); err != nil {
+		t.Fatal(err)
+	}
+	body := new(CMsgClientFriendMsg)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	if EChatEntryType(body.GetChatEntryType()) != EChatEntryType_InviteGame {
+		t.Fatalf("Expected entry type InviteGame, got %v", EChatEntryType(body.GetChatEntryType()))
+	}
+	if string(body.GetMessage()) != "+connect 127.0.0.1:27015" {
+		t.Fatalf("Unexpected payload %q", body.GetMessage())
+	}
+
+	room := steamid.NewIdAdv(3, 0, int32(EUniverse_Public), EAccountType_Chat)
+	if err := client.Social.SendGameInvite(room, "+connect 127.0.0.1:27015"); err == nil {
+		t.Fatal("Expected an error for a chat room")
+	}
+}
+
+func TestFriendsListRelationshipChange(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Name: "pending", Relationship: EFriendRelationship_RequestRecipient})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsList, &CMsgClientFriendsList{
+		Bincremental: proto.Bool(true),
+		Friends: []*CMsgClientFriendsList_Friend{
+			{Ulfriendid: proto.Uint64(friend.ToUint64()), Efriendrelationship: proto.Uint32(uint32(EFriendRelationship_Friend))},
+		},
+	}))
+	event := nextEvent(t, client).(*FriendStateEvent)
+	if !event.IsFriend() {
+		t.Fatalf("Expected a friend state event, got %+v", event)
+	}
+
+	cached, err := client.Social.Friends.ById(friend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Relationship != EFriendRelationship_Friend {
+		t.Fatalf("Expected relationship Friend, got %v", cached.Relationship)
+	}
+	if cached.Name != "pending" {
+		t.Fatalf("Expected cached name to be kept, got %q", cached.Name)
+	}
+}
+
+func TestGetProfileInfo(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+
+	type result struct {
+		info *ProfileInfoEvent
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		info, err := client.Social.GetProfileInfo(context.Background(), friend)
+		results <- result{info, err}
+	}()
+
+	<-client.writeChan // the request
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendProfileInfoResponse, &CMsgClientFriendProfileInfoResponse{
+		Eresult:       proto.Int32(int32(EResult_OK)),
+		SteamidFriend: proto.Uint64(friend.ToUint64()),
+		RealName:      proto.String("Real Name"),
+	}))
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.info.SteamId != friend || r.info.RealName != "Real Name" {
+		t.Fatalf("Unexpected profile info %+v", r.info)
+	}
+	if _, ok := nextEvent(t, client).(*ProfileInfoEvent); !ok {
+		t.Fatal("Expected the ProfileInfoEvent to be emitted as well")
+	}
+}
+
+func TestGetProfileInfoCached(t *testing.T) {
+	client := newTestClient()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	client.Social.profileCache = newProfileCache(clock.Now)
+	friend := steamid.NewIndividual(2)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendProfileInfoResponse, &CMsgClientFriendProfileInfoResponse{
+		Eresult:       proto.Int32(int32(EResult_OK)),
+		SteamidFriend: proto.Uint64(friend.ToUint64()),
+		RealName:      proto.String("Real Name"),
+	}))
+	nextEvent(t, client)
+
+	clock.Advance(time.Minute)
+	info, err := client.Social.GetProfileInfoCached(context.Background(), friend, 5*time.Minute)
+	if err != nil || info.RealName != "Real Name" {
+		t.Fatalf("Expected the cached profile info, got %+v, %v", info, err)
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no request within the ttl")
+	}
+
+	clock.Advance(5 * time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Social.GetProfileInfoCached(ctx, friend, 5*time.Minute); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the expired profile info to be requested again, got %v", err)
+	}
+	body := new(CMsgClientFriendProfileInfo)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	if steamid.SteamId(body.GetSteamidFriend()) != friend {
+		t.Fatalf("Expected a request for %v, got %v", friend, body.GetSteamidFriend())
+	}
+}
+
+func TestGetProfileInfoTimeout(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Social.GetProfileInfo(ctx, friend); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline exceeded error, got %v", err)
+	}
+}
+
+func TestGetPersona(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	other := steamid.NewIdAdv(3, 1, int32(EUniverse_Public), EAccountType_Individual)
+
+	events := make(chan *PersonaStateEvent)
+	go func() {
+		event, err := client.Social.GetPersona(context.Background(), friend, EClientPersonaStateFlag_PlayerName)
+		if err != nil {
+			t.Error(err)
+		}
+		events <- event
+	}()
+
+	<-client.writeChan // the request
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(other.ToUint64()), PlayerName: proto.String("other")},
+			{Friendid: proto.Uint64(friend.ToUint64()), PlayerName: proto.String("friend")},
+		},
+	}))
+	event := <-events
+	if event == nil || event.FriendId != friend || event.Name != "friend" {
+		t.Fatalf("Unexpected persona state %+v", event)
+	}
+}
+
+func TestGetPersonaMismatch(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	other := steamid.NewIdAdv(3, 1, int32(EUniverse_Public), EAccountType_Individual)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	errs := make(chan error)
+	go func() {
+		_, err := client.Social.GetPersona(ctx, friend, EClientPersonaStateFlag_PlayerName)
+		errs <- err
+	}()
+
+	<-client.writeChan // the request
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(other.ToUint64()), PlayerName: proto.String("other")},
+		},
+	}))
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline exceeded error, got %v", err)
+	}
+}
+
+func TestSendRateLimitWait(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.SetSendRateLimit(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("Expected messages to be spaced out by 50ms, took %v", elapsed)
+	}
+	if len(client.writeChan) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(client.writeChan))
+	}
+}
+
+func TestSendRateLimitReject(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.SetSendRateLimit(1, 2)
+	client.Social.SetSendRatePolicy(SendRateReject)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Social.SendMessage(friend, EChatEntryType_Typing, ""); err != ErrSendRateLimited {
+		t.Fatalf("Expected ErrSendRateLimited, got %v", err)
+	}
+	if len(client.writeChan) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(client.writeChan))
+	}
+}
+
+func TestResync(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	chatId := steamid.NewIdAdv(3, 0, int32(EUniverse_Public), EAccountType_Chat)
+	client.Social.Friends.Add(socialcache.Friend{
+		SteamId:      friend,
+		PersonaState: EPersonaState_Online,
+		GameAppId:    440,
+		RichPresence: map[string]string{"status": "Playing"},
+	})
+	client.Social.Chats.Add(socialcache.Chat{SteamId: chatId, Joined: true})
+	client.Social.Chats.Add(socialcache.Chat{SteamId: chatId + 1})
+
+	client.Social.Resync()
+
+	request := new(CMsgClientRequestFriendData)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(request)
+	if len(request.GetFriends()) != 1 || steamid.SteamId(request.GetFriends()[0]) != friend {
+		t.Fatalf("Expected a persona request for %v, got %v", friend, request.GetFriends())
+	}
+	join := new(MsgClientJoinChat)
+	toPacket(t, nextWritten(t, client)).ReadClientMsg(join)
+	if steamid.SteamId(join.SteamIdChat) != chatId {
+		t.Fatalf("Expected to rejoin %v, got %v", chatId, join.SteamIdChat)
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected only joined chats to be rejoined")
+	}
+
+	cached, _ := client.Social.Friends.ById(friend)
+	if cached.PersonaState != EPersonaState_Offline || cached.GameAppId != 0 || cached.RichPresence != nil {
+		t.Fatalf("Expected presence to be cleared, got %+v", cached)
+	}
+}
+
+func TestChatJoinedState(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewIdAdv(3, 0, int32(EUniverse_Public), EAccountType_Chat)
+
+	client.Social.JoinChat(chatId)
+	if joined := client.Social.Chats.GetJoined(); len(joined) != 1 || joined[0].SteamId != chatId {
+		t.Fatalf("Expected %v to be joined, got %v", chatId, joined)
+	}
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Success,
+	}, []byte("room\x00\x00"))))
+	event := nextEvent(t, client).(*ChatEnterEvent)
+	chat, _ := client.Social.Chats.ById(chatId)
+	if !chat.Joined || chat.Name != event.Name || chat.Name != "room" {
+		t.Fatalf("Expected a joined chat named room, got %+v", chat)
+	}
+
+	client.Social.LeaveChat(chatId)
+	if joined := client.Social.Chats.GetJoined(); len(joined) != 0 {
+		t.Fatalf("Expected no joined chats, got %v", joined)
+	}
+	if _, err := client.Social.Chats.ById(chatId); err == nil {
+		t.Fatal("Expected the chat to be removed from the cache")
+	}
+}
+
+func TestLeaveChat(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(4)
+	client.Social.Chats.AddChatMember(clan.ClanToChat(), socialcache.ChatMember{SteamId: testSelfId})
+	client.Social.LeaveChat(clan)
+
+	if _, err := client.Social.Chats.ById(clan.ClanToChat()); err == nil {
+		t.Fatal("Expected the chat to be removed from the cache")
+	}
+	body := new(MsgClientChatMemberInfo)
+	payload := bytes.NewBuffer(toPacket(t, nextWritten(t, client)).ReadClientMsg(body).Payload)
+	if steamid.SteamId(body.SteamIdChat) != clan.ClanToChat() || body.Type != EChatInfoType_StateChange {
+		t.Fatalf("Unexpected message %+v", body)
+	}
+	actedOn, _ := ReadUint64(payload)
+	state, _ := ReadInt32(payload)
+	actedBy, _ := ReadUint64(payload)
+	if steamid.SteamId(actedOn) != testSelfId || steamid.SteamId(actedBy) != testSelfId || EChatMemberStateChange(state) != EChatMemberStateChange_Left {
+		t.Fatalf("Unexpected state change %v by %v on %v", EChatMemberStateChange(state), actedBy, actedOn)
+	}
+	// unlike entering members, a leaving member is sent without a MessageObject
+	if payload.Len() != 0 {
+		t.Fatalf("Expected nothing after the state change, got %d bytes", payload.Len())
+	}
+
+	// being kicked removes the chat as well
+	chatId := steamid.NewChat(5)
+	client.Social.Chats.AddChatMember(chatId, socialcache.ChatMember{SteamId: testSelfId})
+	kicked := new(bytes.Buffer)
+	WriteUint64(kicked, testSelfId.ToUint64())
+	WriteInt32(kicked, int32(EChatMemberStateChange_Kicked))
+	WriteUint64(kicked, steamid.NewIndividual(2).ToUint64())
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_StateChange,
+	}, kicked.Bytes())))
+	nextEvent(t, client)
+	if _, err := client.Social.Chats.ById(chatId); err == nil {
+		t.Fatal("Expected the chat to be removed after being kicked")
+	}
+}
+
+func TestSetPersonaName(t *testing.T) {
+	client := newTestClient()
+	if err := client.Social.SetPersonaName(""); err == nil {
+		t.Fatal("Expected an error for an empty name")
+	}
+	if err := client.Social.SetPersonaName(strings.Repeat("a", MaxPersonaNameLength+1)); err == nil {
+		t.Fatal("Expected an error for a too long name")
+	}
+	if len(client.writeChan) != 0 || client.Social.GetPersonaName() != "" {
+		t.Fatal("Expected invalid names not to be set or sent")
+	}
+
+	name := strings.Repeat("a", MaxPersonaNameLength)
+	if err := client.Social.SetPersonaName(name); err != nil {
+		t.Fatal(err)
+	}
+	body := new(CMsgClientChangeStatus)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	if body.GetPlayerName() != name {
+		t.Fatalf("Expected name to be sent, got %q", body.GetPlayerName())
+	}
+}
+
+func TestPersonaNameChangeResponse(t *testing.T) {
+	client := newTestClient()
+	if err := client.Social.SetPersonaName("taken"); err != nil {
+		t.Fatal(err)
+	}
+	nextWritten(t, client)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaChangeResponse, &CMsgPersonaChangeResponse{
+		Result: proto.Uint32(uint32(EResult_DuplicateName)),
+	}))
+	if e, ok := nextEvent(t, client).(*PersonaNameChangeEvent); !ok || e.Result != EResult_DuplicateName || e.Name != "taken" {
+		t.Fatalf("Expected a rejected PersonaNameChangeEvent, got %#v", e)
+	}
+	if name := client.Social.GetPersonaName(); name != "" {
+		t.Fatalf("Expected a rejected name not to be cached, got %q", name)
+	}
+
+	if err := client.Social.SetPersonaName("me"); err != nil {
+		t.Fatal(err)
+	}
+	nextWritten(t, client)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaChangeResponse, &CMsgPersonaChangeResponse{
+		Result:     proto.Uint32(uint32(EResult_OK)),
+		PlayerName: proto.String("me"),
+	}))
+	if e, ok := nextEvent(t, client).(*PersonaNameChangeEvent); !ok || e.Result != EResult_OK || e.Name != "me" {
+		t.Fatalf("Expected an accepted PersonaNameChangeEvent, got %#v", e)
+	}
+	if name := client.Social.GetPersonaName(); name != "me" {
+		t.Fatalf("Expected accepted name to be cached, got %q", name)
+	}
+}
+
+func TestProfileInfoPrivate(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendProfileInfoResponse, &CMsgClientFriendProfileInfoResponse{
+		Eresult:       proto.Int32(int32(EResult_OK)),
+		SteamidFriend: proto.Uint64(friend.ToUint64()),
+		TimeCreated:   proto.Uint32(1500000000),
+		Headline:      proto.String("headline"),
+	}))
+	public := nextEvent(t, client).(*ProfileInfoEvent)
+	if public.IsPrivate() || public.Headline != "headline" || public.TimeCreated != 1500000000 {
+		t.Fatalf("Unexpected public profile %+v", public)
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendProfileInfoResponse, &CMsgClientFriendProfileInfoResponse{
+		Eresult:       proto.Int32(int32(EResult_AccessDenied)),
+		SteamidFriend: proto.Uint64(friend.ToUint64()),
+	}))
+	private := nextEvent(t, client).(*ProfileInfoEvent)
+	if !private.IsPrivate() || private.SteamId != friend {
+		t.Fatalf("Expected a private profile, got %+v", private)
+	}
+}
+
+func TestLeaveAllChats(t *testing.T) {
+	client := newTestClient()
+	client.Social.LeaveAllChats()
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no messages without joined chats")
+	}
+
+	chats := map[steamid.SteamId]bool{}
+	for i := uint32(1); i <= 3; i++ {
+		id := steamid.NewIdAdv(i, 0, int32(EUniverse_Public), EAccountType_Chat)
+		chats[id] = true
+		client.Social.Chats.Add(socialcache.Chat{SteamId: id, Joined: true})
+	}
+	client.Social.Chats.Add(socialcache.Chat{SteamId: steamid.NewIdAdv(4, 0, int32(EUniverse_Public), EAccountType_Chat)})
+
+	client.Social.LeaveAllChats()
+	for len(client.writeChan) > 0 {
+		body := new(MsgClientChatMemberInfo)
+		toPacket(t, nextWritten(t, client)).ReadClientMsg(body)
+		if !chats[steamid.SteamId(body.SteamIdChat)] {
+			t.Fatalf("Unexpected leave for %v", body.SteamIdChat)
+		}
+		delete(chats, steamid.SteamId(body.SteamIdChat))
+	}
+	if len(chats) != 0 {
+		t.Fatalf("Expected to leave %v", chats)
+	}
+}
+
+func TestPersonaStateClanTag(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewIdAdv(5, 0, int32(EUniverse_Public), EAccountType_Clan)
+	client.Social.Groups.Add(socialcache.Group{SteamId: clan, Relationship: EClanRelationship_Member})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName | EClientPersonaStateFlag_ClanTag)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(clan.ToUint64()), PlayerName: proto.String("Group"), ClanTag: proto.String("TAG")},
+		},
+	}))
+	nextEvent(t, client)
+
+	group, _ := client.Social.Groups.ById(clan)
+	if group.ClanTag != "TAG" || group.Name != "Group" {
+		t.Fatalf("Expected [TAG] Group, got %+v", group)
+	}
+}
+
+func TestPersonaStateRichPresence(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Relationship: EFriendRelationship_Friend})
+
+	var kv []byte
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, "steam_display")
+	kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+	kv = protowire.AppendString(kv, "#Playing")
+	var raw []byte
+	raw = protowire.AppendTag(raw, personaStateRichPresenceField, protowire.BytesType)
+	raw = protowire.AppendBytes(raw, kv)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_Presence)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(friend.ToUint64()), XXX_unrecognized: raw},
+		},
+	}))
+	nextEvent(t, client)
+
+	richPresence, ok := client.Social.Friends.GetRichPresence(friend)
+	if !ok || richPresence["steam_display"] != "#Playing" {
+		t.Fatalf("Expected steam_display #Playing, got %v", richPresence)
+	}
+}
+
+func TestRequestProfileInfoBatch(t *testing.T) {
+	client := newTestClient()
+	client.Social.SetProfileInfoRateLimit(20, 1)
+	ids := []steamid.SteamId{
+		steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual),
+		steamid.NewIdAdv(3, 1, int32(EUniverse_Public), EAccountType_Individual),
+		steamid.NewIdAdv(4, 1, int32(EUniverse_Public), EAccountType_Individual),
+	}
+
+	start := time.Now()
+	client.Social.RequestProfileInfoBatch(ids)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("Expected requests to be spaced out by 50ms, took %v", elapsed)
+	}
+	for _, id := range ids {
+		body := new(CMsgClientFriendProfileInfo)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		if steamid.SteamId(body.GetSteamidFriend()) != id {
+			t.Fatalf("Expected a request for %v, got %v", id, body.GetSteamidFriend())
+		}
+	}
+}
+
+func TestAddFriends(t *testing.T) {
+	client := newTestClient()
+	client.Social.SetSendRateLimit(20, 1)
+	friend := steamid.NewIndividual(2)
+	pending := steamid.NewIndividual(3)
+	stranger := steamid.NewIndividual(4)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Relationship: EFriendRelationship_Friend})
+	client.Social.Friends.Add(socialcache.Friend{SteamId: pending, Relationship: EFriendRelationship_RequestInitiator})
+
+	start := time.Now()
+	client.Social.AddFriends([]steamid.SteamId{friend, pending, stranger})
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("Expected requests to be spaced out by 50ms, took %v", elapsed)
+	}
+	if len(client.writeChan) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(client.writeChan))
+	}
+	for _, id := range []steamid.SteamId{pending, stranger} {
+		body := new(CMsgClientAddFriend)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		if steamid.SteamId(body.GetSteamidToAdd()) != id {
+			t.Fatalf("Expected a request for %v, got %v", id, body.GetSteamidToAdd())
+		}
+	}
+}
+
+func TestPruneFriends(t *testing.T) {
+	client := newTestClient()
+	relationships := map[steamid.SteamId]EFriendRelationship{
+		steamid.NewIndividual(2): EFriendRelationship_Friend,
+		steamid.NewIndividual(3): EFriendRelationship_RequestInitiator,
+		steamid.NewIndividual(4): EFriendRelationship_RequestRecipient,
+		steamid.NewIndividual(5): EFriendRelationship_RequestInitiator,
+	}
+	for id, rel := range relationships {
+		client.Social.Friends.Add(socialcache.Friend{SteamId: id, Relationship: rel})
+	}
+
+	if count := client.Social.PruneFriends(EFriendRelationship_RequestInitiator); count != 2 {
+		t.Fatalf("Expected 2 friends to be removed, got %d", count)
+	}
+	if len(client.writeChan) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(client.writeChan))
+	}
+	for len(client.writeChan) > 0 {
+		body := new(CMsgClientRemoveFriend)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		if rel := relationships[steamid.SteamId(body.GetFriendid())]; rel != EFriendRelationship_RequestInitiator {
+			t.Fatalf("Expected only outgoing requests to be removed, removed %v with relationship %v", body.GetFriendid(), rel)
+		}
+	}
+}
+
+func TestClanStateCounts(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(4)
+	client.Social.Groups.Add(socialcache.Group{SteamId: clan, MemberTotalCount: 100, MemberOnlineCount: 10})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientClanState, &CMsgClientClanState{
+		SteamidClan:    proto.Uint64(clan.ToUint64()),
+		MUnStatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		NameInfo:       &CMsgClientClanState_NameInfo{ClanName: proto.String("clan")},
+	}))
+	e := nextEvent(t, client).(*ClanStateEvent)
+	if e.HasCounts {
+		t.Fatalf("Expected no counts, got %+v", e)
+	}
+	group, _ := client.Social.Groups.ById(clan)
+	if group.MemberTotalCount != 100 || group.MemberOnlineCount != 10 {
+		t.Fatalf("Expected the cached counts to be kept, got %+v", group)
+	}
+
+	callbacks := 0
+	client.Social.Groups.OnMemberCountChange(func(steamid.SteamId, uint32) { callbacks++ })
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientClanState, &CMsgClientClanState{
+		SteamidClan: proto.Uint64(clan.ToUint64()),
+		UserCounts:  &CMsgClientClanState_UserCounts{Members: proto.Uint32(101), Online: proto.Uint32(0), InGame: proto.Uint32(1)},
+	}))
+	e = nextEvent(t, client).(*ClanStateEvent)
+	if !e.HasCounts || e.MemberTotalCount != 101 || e.MemberOnlineCount != 0 {
+		t.Fatalf("Expected counts, got %+v", e)
+	}
+	if callbacks != 1 {
+		t.Fatalf("Expected a single member count callback, got %d", callbacks)
+	}
+}
+
+// writeChatMember writes a chat member in the format read by readChatMember
+func writeChatMember(w *bytes.Buffer, id steamid.SteamId, name string, chatPerm EChatPermission, clanPerm EClanPermission) {
+	kv := NewKeyValuesWriter(w, "MessageObject")
+	kv.WriteUint64("steamid", id.ToUint64())
+	if name != "" {
+		kv.WriteString("name", name)
+	}
+	kv.WriteInt32("Permissions", int32(chatPerm))
+	kv.WriteInt32("Details", int32(clanPerm))
+	kv.End()
+}
+
+func TestKeyValuesRoundTrip(t *testing.T) {
+	member := steamid.NewIndividual(2)
+	buf := new(bytes.Buffer)
+	kv := NewKeyValuesWriter(buf, "MessageObject")
+	kv.BeginSection("extra")
+	kv.WriteString("name", "nested")
+	kv.WriteUint64("steamid", 1)
+	kv.End()
+	kv.WriteUint64("steamid", member.ToUint64())
+	kv.WriteString("PersonaName", "Gabe")
+	kv.WriteInt32("Permissions", int32(EChatPermission_Talk|EChatPermission_Kick))
+	kv.WriteInt32("Details", int32(EClanPermission_Officer))
+	kv.End()
+	buf.WriteString("after")
+
+	read, err := readChatMember(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := socialcache.ChatMember{
+		SteamId:         member,
+		Name:            "Gabe",
+		ChatPermissions: EChatPermission_Talk | EChatPermission_Kick,
+		ClanPermissions: EClanPermission_Officer,
+	}
+	if read != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, read)
+	}
+	if buf.String() != "after" {
+		t.Fatalf("Expected the member to be read up to its end marker, %q is left", buf.String())
+	}
+}
+
+func TestChatEnterRefreshesMembers(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewIdAdv(3, 0, int32(EUniverse_Public), EAccountType_Chat)
+	phantom := steamid.NewIdAdv(2, 1, int32(EUniverse_Public), EAccountType_Individual)
+	client.Social.Chats.AddChatMember(chatId, socialcache.ChatMember{SteamId: phantom})
+
+	payload := new(bytes.Buffer)
+	payload.WriteString("room\x00\x00")
+	writeChatMember(payload, testSelfId, "", EChatPermission_Talk, 0)
+	payload.Write(make([]byte, 5))
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Success,
+		NumMembers:    1,
+	}, payload.Bytes())))
+
+	nextEvent(t, client) // ChatEnterEvent
+	refreshed := nextEvent(t, client).(*ChatMembersRefreshedEvent)
+	if refreshed.ChatRoomId != chatId || len(refreshed.Members) != 1 || refreshed.Members[0].SteamId != testSelfId {
+		t.Fatalf("Unexpected refresh %+v", refreshed)
+	}
+	members, _ := client.Social.Chats.GetMembers(chatId)
+	if len(members) != 1 || members[0].SteamId != testSelfId || members[0].ChatPermissions != EChatPermission_Talk {
+		t.Fatalf("Expected the member list to be replaced, got %+v", members)
+	}
+}
+
+func TestChatMemberName(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	member := steamid.NewIndividual(2)
+
+	payload := new(bytes.Buffer)
+	payload.WriteString("room\x00\x00")
+	payload.WriteString("MessageObject\x00")
+	payload.WriteByte(0) // a nested object that should be skipped
+	payload.WriteString("extra\x00")
+	payload.WriteByte(1)
+	payload.WriteString("name\x00nested\x00")
+	payload.WriteByte(8)
+	payload.WriteByte(7)
+	payload.WriteString("steamid\x00")
+	binary.Write(payload, binary.LittleEndian, member.ToUint64())
+	payload.WriteByte(1)
+	payload.WriteString("PersonaName\x00Gabe\x00")
+	payload.WriteByte(2)
+	payload.WriteString("Permissions\x00")
+	binary.Write(payload, binary.LittleEndian, int32(EChatPermission_Talk))
+	payload.WriteByte(8)
+	payload.Write(make([]byte, 5))
+	writeChatMember(payload, testSelfId, "me", EChatPermission_Talk, 0)
+	payload.Write(make([]byte, 5))
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Success,
+		NumMembers:    2,
+	}, payload.Bytes())))
+
+	for id, expected := range map[steamid.SteamId]string{member: "Gabe", testSelfId: "me"} {
+		if name, err := client.Social.Chats.GetMemberName(chatId, id); err != nil || name != expected {
+			t.Fatalf("%v: expected name %q, got %q, %v", id, expected, name, err)
+		}
+	}
+	chatPerm, _, _ := client.Social.Chats.GetMemberPermissions(chatId, member)
+	if chatPerm != EChatPermission_Talk {
+		t.Fatalf("Expected the fields after the nested object to be read, got %v", chatPerm)
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(member.ToUint64()), PlayerName: proto.String("Gaben")},
+		},
+	}))
+	if name, _ := client.Social.Chats.GetMemberName(chatId, member); name != "Gaben" {
+		t.Fatalf("Expected the member to be renamed, got %q", name)
+	}
+}
+
+func TestChatEnterClanAndMUC(t *testing.T) {
+	client := newTestClient()
+	enter := func(chatId, clanId steamid.SteamId) *ChatEnterEvent {
+		payload := new(bytes.Buffer)
+		payload.WriteString("room\x00\x00")
+		writeChatMember(payload, testSelfId, "me", EChatPermission_Talk, 0)
+		payload.Write(make([]byte, 5))
+		client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+			SteamIdChat:   SteamId(chatId),
+			SteamIdClan:   SteamId(clanId),
+			EnterResponse: EChatRoomEnterResponse_Success,
+			NumMembers:    1,
+		}, payload.Bytes())))
+		event := nextEvent(t, client).(*ChatEnterEvent)
+		nextEvent(t, client) // ChatMembersRefreshedEvent
+		return event
+	}
+
+	clanChat := steamid.NewClan(4).ClanToChat()
+	if event := enter(clanChat, 0); event.ClanId != steamid.NewClan(4) {
+		t.Fatalf("Expected the clan of a clan chat, got %v", event.ClanId)
+	}
+	if chat, _ := client.Social.Chats.ById(clanChat); chat.GroupId != steamid.NewClan(4) {
+		t.Fatalf("Expected the clan chat to belong to its clan, got %v", chat.GroupId)
+	}
+
+	muc := steamid.NewChat(4)
+	if event := enter(muc, steamid.NewClan(5)); event.ClanId != 0 {
+		t.Fatalf("Expected no clan for an ad-hoc chat, got %v", event.ClanId)
+	}
+	if chat, _ := client.Social.Chats.ById(muc); chat.GroupId != 0 {
+		t.Fatalf("Expected the ad-hoc chat not to belong to a clan, got %v", chat.GroupId)
+	}
+}
+
+func TestChatEnterFailure(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	client.Social.JoinChat(chatId)
+	nextWritten(t, client)
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Banned,
+	}, []byte("room\x00\x00"))))
+
+	event := nextEvent(t, client).(*ChatEnterEvent)
+	if event.Success() || event.Err() == nil {
+		t.Fatal("Expected a failed chat enter")
+	}
+	if _, err := client.Social.Chats.ById(chatId); err == nil {
+		t.Fatal("Expected the chat not to be cached")
+	}
+}
+
+func TestChatEnterEventErr(t *testing.T) {
+	if err := (&ChatEnterEvent{EnterResponse: EChatRoomEnterResponse_Success}).Err(); err != nil {
+		t.Fatalf("Expected no error on success, got %v", err)
+	}
+	for _, response := range []EChatRoomEnterResponse{EChatRoomEnterResponse_Banned, EChatRoomEnterResponse_Full, EChatRoomEnterResponse_NotAllowed} {
+		event := &ChatEnterEvent{EnterResponse: response}
+		if event.Success() {
+			t.Fatalf("Expected %v not to be a success", response)
+		}
+		if err := event.Err(); err == nil || !strings.Contains(err.Error(), response.String()) {
+			t.Fatalf("Expected an error naming %v, got %v", response, err)
+		}
+	}
+}
+
+func TestEventSubscriptions(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+
+	var chatMsgs []*ChatMsgEvent
+	var personaStates []*PersonaStateEvent
+	unsubscribe := client.Social.OnChatMsg(func(e *ChatMsgEvent) { chatMsgs = append(chatMsgs, e) })
+	client.Social.OnPersonaState(func(e *PersonaStateEvent) { personaStates = append(personaStates, e) })
+
+	msg := newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:   proto.Uint64(friend.ToUint64()),
+		ChatEntryType: proto.Int32(int32(EChatEntryType_ChatMsg)),
+		Message:       []byte("hello"),
+	})
+	client.Social.HandlePacket(msg)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		Friends: []*CMsgClientPersonaState_Friend{{Friendid: proto.Uint64(friend.ToUint64())}},
+	}))
+	unsubscribe()
+	client.Social.HandlePacket(msg)
+
+	if len(chatMsgs) != 1 || chatMsgs[0].Message != "hello" {
+		t.Fatalf("Expected one chat message, got %v", chatMsgs)
+	}
+	if len(personaStates) != 1 || personaStates[0].FriendId != friend {
+		t.Fatalf("Expected one persona state, got %v", personaStates)
+	}
+	if len(client.events) != 3 {
+		t.Fatalf("Expected all events to be emitted as well, got %d", len(client.events))
+	}
+}
+
+func TestSendMessageToGroup(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(5)
+	if err := client.Social.SendMessageToGroup(clan, EChatEntryType_ChatMsg, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	body := new(MsgClientChatMsg)
+	payload := toPacket(t, nextWritten(t, client)).ReadClientMsg(body).Payload
+	if steamid.SteamId(body.SteamIdChatRoom) != clan.ClanToChat() || string(payload) != "hello" {
+		t.Fatalf("Expected hello to be sent to %v, got %q to %v", clan.ClanToChat(), payload, body.SteamIdChatRoom)
+	}
+
+	if err := client.Social.SendMessageToGroup(steamid.NewIndividual(2), EChatEntryType_ChatMsg, "hello"); err == nil {
+		t.Fatal("Expected an error for an individual")
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no message for an individual")
+	}
+}
+
+func TestFriendsGroupsList(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	other := steamid.NewIndividual(3)
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsGroupsList, &CMsgClientFriendsGroupsList{
+		Bremoval:     proto.Bool(false),
+		Bincremental: proto.Bool(false),
+		FriendGroups: []*CMsgClientFriendsGroupsList_FriendGroup{
+			{NGroupID: proto.Int32(1), StrGroupName: proto.String("Team")},
+			{NGroupID: proto.Int32(2), StrGroupName: proto.String("Work")},
+		},
+		Memberships: []*CMsgClientFriendsGroupsList_FriendGroupsMembership{
+			{UlSteamID: proto.Uint64(friend.ToUint64()), NGroupID: proto.Int32(1)},
+			{UlSteamID: proto.Uint64(other.ToUint64()), NGroupID: proto.Int32(1)},
+			{UlSteamID: proto.Uint64(other.ToUint64()), NGroupID: proto.Int32(2)},
+		},
+	}))
+
+	if e, ok := nextEvent(t, client).(*FriendCategoriesEvent); !ok || e.Incremental {
+		t.Fatalf("Expected a full FriendCategoriesEvent, got %#v", e)
+	}
+	category, err := client.Social.Categories.ById(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.Name != "Team" || len(category.Members) != 2 {
+		t.Fatalf("Unexpected category %+v", category)
+	}
+	if categories := client.Social.Categories.ByMember(other); len(categories) != 2 {
+		t.Fatalf("Expected 2 categories for %v, got %d", other, len(categories))
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsGroupsList, &CMsgClientFriendsGroupsList{
+		Bremoval:     proto.Bool(true),
+		Bincremental: proto.Bool(true),
+		FriendGroups: []*CMsgClientFriendsGroupsList_FriendGroup{{NGroupID: proto.Int32(2)}},
+		Memberships: []*CMsgClientFriendsGroupsList_FriendGroupsMembership{
+			{UlSteamID: proto.Uint64(friend.ToUint64()), NGroupID: proto.Int32(1)},
+		},
+	}))
+
+	nextEvent(t, client)
+	if client.Social.Categories.Count() != 1 {
+		t.Fatalf("Expected 1 category, got %d", client.Social.Categories.Count())
+	}
+	members, _ := client.Social.Categories.GetMembers(1)
+	if len(members) != 1 || members[0] != other {
+		t.Fatalf("Unexpected members %v", members)
+	}
+}
+
+func TestAckFriendMessage(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	client.Social.AckFriendMessage(friend)
+
+	msg, ok := nextWritten(t, client).(*ClientMsgProtobuf)
+	if !ok || msg.GetMsgType() != EMsg_ClientServiceMethod {
+		t.Fatalf("Expected a ClientServiceMethod message, got %#v", msg)
+	}
+	body := msg.Body.(*CMsgClientServiceMethod)
+	if body.GetMethodName() != "FriendMessages.AckMessage#1" || !body.GetIsNotification() {
+		t.Fatalf("Unexpected service method %v", body)
+	}
+	raw := body.GetSerializedMethod()
+	num, typ, n := protowire.ConsumeTag(raw)
+	if num != 1 || typ != protowire.Fixed64Type {
+		t.Fatalf("Expected steamid_partner as the first field, got %d (%v)", num, typ)
+	}
+	partner, _ := protowire.ConsumeFixed64(raw[n:])
+	if steamid.SteamId(partner) != friend {
+		t.Fatalf("%v != %v", steamid.SteamId(partner), friend)
+	}
+}
+
+func TestAutoAckFriendMessage(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	incoming := func(entryType EChatEntryType) *Packet {
+		return newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+			SteamidFrom:            proto.Uint64(friend.ToUint64()),
+			ChatEntryType:          proto.Int32(int32(entryType)),
+			Message:                []byte("hi\x00"),
+			Rtime32ServerTimestamp: proto.Uint32(1000),
+		})
+	}
+
+	client.Social.HandlePacket(incoming(EChatEntryType_ChatMsg))
+	nextEvent(t, client)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no ack without auto-ack")
+	}
+
+	client.Social.SetAutoAckFriendMessages(true)
+	client.Social.HandlePacket(incoming(EChatEntryType_Typing))
+	nextEvent(t, client)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no ack for typing notifications")
+	}
+	client.Social.HandlePacket(incoming(EChatEntryType_ChatMsg))
+	nextEvent(t, client)
+	if msg := nextWritten(t, client); msg.GetMsgType() != EMsg_ClientServiceMethod {
+		t.Fatalf("Expected an ack, got %v", msg.GetMsgType())
+	}
+}
+
+func TestAccountInfo(t *testing.T) {
+	client := newTestClient()
+	client.handlePacket(newProtoPacket(t, EMsg_ClientAccountInfo, &CMsgClientAccountInfo{
+		PersonaName:  proto.String("me"),
+		IpCountry:    proto.String("DE"),
+		AccountFlags: proto.Uint32(uint32(EAccountFlags_PersonaNameSet | EAccountFlags_PasswordSet)),
+	}))
+
+	e, ok := nextEvent(t, client).(*AccountInfoEvent)
+	if !ok {
+		t.Fatalf("Expected an AccountInfoEvent, got %#v", e)
+	}
+	if e.PersonaName != "me" || e.Country != "DE" || e.AccountFlags != EAccountFlags_PersonaNameSet|EAccountFlags_PasswordSet {
+		t.Fatalf("Unexpected event %+v", e)
+	}
+	if name := client.Social.GetPersonaName(); name != "me" {
+		t.Fatalf("Expected cached persona name me, got %q", name)
+	}
+
+	msg := nextWritten(t, client)
+	if msg.GetMsgType() != EMsg_ClientRequestFriendData {
+		t.Fatalf("Expected a persona request, got %v", msg.GetMsgType())
+	}
+	body := msg.(*ClientMsgProtobuf).Body.(*CMsgClientRequestFriendData)
+	if len(body.GetFriends()) != 1 || steamid.SteamId(body.GetFriends()[0]) != testSelfId {
+		t.Fatalf("Expected a persona request for ourselves, got %v", body.GetFriends())
+	}
+}
+
+func TestSetPersona(t *testing.T) {
+	client := newTestClient()
+	if err := client.Social.SetPersona("me", EPersonaState_Busy); err != nil {
+		t.Fatal(err)
+	}
+
+	body := nextWritten(t, client).(*ClientMsgProtobuf).Body.(*CMsgClientChangeStatus)
+	if body.GetPlayerName() != "me" || EPersonaState(body.GetPersonaState()) != EPersonaState_Busy {
+		t.Fatalf("Unexpected change status %v", body)
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected a single outgoing message")
+	}
+	if client.Social.GetPersonaState() != EPersonaState_Busy {
+		t.Fatal("Expected the persona state to be cached")
+	}
+
+	if err := client.Social.SetPersona("", EPersonaState_Online); err == nil {
+		t.Fatal("Expected an error for an empty name")
+	}
+	if len(client.writeChan) != 0 || client.Social.GetPersonaState() != EPersonaState_Busy {
+		t.Fatal("Expected an invalid name not to change the persona")
+	}
+}
+
+func TestEmoteRoundTrip(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	chatId := steamid.NewChat(3)
+
+	if err := client.Social.SendEmote(friend, "waves"); err != nil {
+		t.Fatal(err)
+	}
+	friendMsg := new(CMsgClientFriendMsg)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(friendMsg)
+	if EChatEntryType(friendMsg.GetChatEntryType()) != EChatEntryType_Emote || string(friendMsg.GetMessage()) != "waves" {
+		t.Fatalf("Unexpected friend message %v", friendMsg)
+	}
+
+	if err := client.Social.SendEmote(chatId, "waves"); err != nil {
+		t.Fatal(err)
+	}
+	chatMsg := new(MsgClientChatMsg)
+	toPacket(t, nextWritten(t, client)).ReadClientMsg(chatMsg)
+	if chatMsg.ChatMsgType != EChatEntryType_Emote {
+		t.Fatalf("Expected an emote chat message, got %v", chatMsg.ChatMsgType)
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:   proto.Uint64(friend.ToUint64()),
+		ChatEntryType: proto.Int32(int32(EChatEntryType_Emote)),
+		Message:       []byte("waves back\x00"),
+	}))
+	if e := nextEvent(t, client).(*ChatMsgEvent); e.EntryType != EChatEntryType_Emote || e.Message != "waves back" {
+		t.Fatalf("Unexpected friend event %+v", e)
+	}
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMsg{
+		SteamIdChatter:  SteamId(friend),
+		SteamIdChatRoom: SteamId(chatId),
+		ChatMsgType:     EChatEntryType_Emote,
+	}, []byte("waves back\x00"))))
+	if e := nextEvent(t, client).(*ChatMsgEvent); e.EntryType != EChatEntryType_Emote || e.ChatRoomId != SteamId(chatId) || e.IsMessage() {
+		t.Fatalf("Unexpected chat event %+v", e)
+	}
+}
+
+func TestMessageLog(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	chatId := steamid.NewChat(3)
+	friendMsg := func(message string) *Packet {
+		return newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+			SteamidFrom:   proto.Uint64(friend.ToUint64()),
+			ChatEntryType: proto.Int32(int32(EChatEntryType_ChatMsg)),
+			Message:       []byte(message),
+		})
+	}
+
+	client.Social.HandlePacket(friendMsg("ignored"))
+	nextEvent(t, client)
+	if log := client.Social.GetMessageLog(friend); log != nil {
+		t.Fatalf("Expected no log while disabled, got %v", log)
+	}
+
+	client.Social.EnableMessageLog(3)
+	for _, message := range []string{"1", "2", "3", "4", "5"} {
+		client.Social.HandlePacket(friendMsg(message))
+		nextEvent(t, client)
+	}
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:   proto.Uint64(friend.ToUint64()),
+		ChatEntryType: proto.Int32(int32(EChatEntryType_Typing)),
+	}))
+	nextEvent(t, client)
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMsg{
+		SteamIdChatter:  SteamId(friend),
+		SteamIdChatRoom: SteamId(chatId),
+		ChatMsgType:     EChatEntryType_ChatMsg,
+	}, []byte("room"))))
+	nextEvent(t, client)
+
+	log := client.Social.GetMessageLog(friend)
+	if len(log) != 3 || log[0].Message != "3" || log[1].Message != "4" || log[2].Message != "5" {
+		t.Fatalf("Expected the last 3 messages oldest first, got %v", log)
+	}
+	if log := client.Social.GetMessageLog(chatId); len(log) != 1 || log[0].Message != "room" {
+		t.Fatalf("Expected the room message to be logged under the room, got %v", log)
+	}
+
+	client.Social.EnableMessageLog(0)
+	if log := client.Social.GetMessageLog(friend); log != nil {
+		t.Fatalf("Expected no log after disabling, got %v", log)
+	}
+}
+
+func TestPersonaFlagsBuilder(t *testing.T) {
+	if flags := PersonaFlags().Build(); flags != 0 {
+		t.Fatalf("Expected no flags, got %v", flags)
+	}
+	flags := PersonaFlags().Name().Presence().GameInfo().Build()
+	if flags != EClientPersonaStateFlag_PlayerName|EClientPersonaStateFlag_Presence|EClientPersonaStateFlag_GameExtraInfo {
+		t.Fatalf("Unexpected flags %v", flags)
+	}
+	all := PersonaFlags().Status().Name().QueryPort().SourceId().Presence().Metadata().LastSeen().
+		ClanInfo().GameInfo().GameDataBlob().ClanTag().Facebook().Name().Build()
+	if all != 4095 {
+		t.Fatalf("Expected all 12 flags, got %d", all)
+	}
+
+	base := PersonaFlags().Name()
+	base.Presence()
+	if base.Build() != EClientPersonaStateFlag_PlayerName {
+		t.Fatal("Expected builders not to be modified in place")
+	}
+}
+
+func TestFullFriendsListRemovesMissing(t *testing.T) {
+	client := newTestClient()
+	kept := steamid.NewIndividual(2)
+	gone := steamid.NewIndividual(3)
+	goneGroup := steamid.NewClan(4)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: kept, Relationship: EFriendRelationship_Friend})
+	client.Social.Friends.Add(socialcache.Friend{SteamId: gone, Relationship: EFriendRelationship_Friend})
+	client.Social.Groups.Add(socialcache.Group{SteamId: goneGroup, Relationship: EClanRelationship_Member})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsList, &CMsgClientFriendsList{
+		Bincremental: proto.Bool(false),
+		Friends: []*CMsgClientFriendsList_Friend{
+			{Ulfriendid: proto.Uint64(kept.ToUint64()), Efriendrelationship: proto.Uint32(uint32(EFriendRelationship_Friend))},
+		},
+	}))
+
+	friendEvent, ok := nextEvent(t, client).(*FriendStateEvent)
+	if !ok || friendEvent.SteamId != gone || friendEvent.Relationship != EFriendRelationship_None {
+		t.Fatalf("Expected a removal event for %v, got %#v", gone, friendEvent)
+	}
+	groupEvent, ok := nextEvent(t, client).(*GroupStateEvent)
+	if !ok || groupEvent.SteamId != goneGroup || groupEvent.Relationship != EClanRelationship_None {
+		t.Fatalf("Expected a removal event for %v, got %#v", goneGroup, groupEvent)
+	}
+	if _, ok := nextEvent(t, client).(*FriendsListEvent); !ok {
+		t.Fatal("Expected a FriendsListEvent")
+	}
+
+	if _, err := client.Social.Friends.ById(gone); err == nil {
+		t.Fatal("Expected the missing friend to be removed")
+	}
+	if _, err := client.Social.Groups.ById(goneGroup); err == nil {
+		t.Fatal("Expected the missing group to be removed")
+	}
+	if _, err := client.Social.Friends.ById(kept); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestClanEvents(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(4)
+	if err := client.Social.RequestClanEvents(steamid.NewIndividual(2)); err == nil {
+		t.Fatal("Expected an error for a non-clan SteamId")
+	}
+	if err := client.Social.RequestClanEvents(clan); err != nil {
+		t.Fatal(err)
+	}
+	request := new(CMsgClientRequestFriendData)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(request)
+	if len(request.GetFriends()) != 1 || steamid.SteamId(request.GetFriends()[0]) != clan ||
+		EClientPersonaStateFlag(request.GetPersonaStateRequested())&EClientPersonaStateFlag_ClanInfo == 0 {
+		t.Fatalf("Unexpected request %v", request)
+	}
+
+	clanState := newProtoPacket(t, EMsg_ClientClanState, &CMsgClientClanState{
+		SteamidClan:    proto.Uint64(clan.ToUint64()),
+		MUnStatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_ClanInfo)),
+		Events: []*CMsgClientClanState_Event{
+			{Gid: proto.Uint64(1), EventTime: proto.Uint32(100), Headline: proto.String("first")},
+			{Gid: proto.Uint64(2), EventTime: proto.Uint32(200), Headline: proto.String("second"), JustPosted: proto.Bool(true)},
+		},
+	})
+	client.Social.HandlePacket(clanState)
+	if _, ok := nextEvent(t, client).(*ClanStateEvent); !ok {
+		t.Fatal("Expected a ClanStateEvent")
+	}
+	e, ok := nextEvent(t, client).(*ClanEventsEvent)
+	if !ok || e.ClanId != clan || len(e.Events) != 2 {
+		t.Fatalf("Unexpected event %#v", e)
+	}
+	if e.Events[0].Headline != "first" || e.Events[1].Id != 2 || e.Events[1].EventTime != 200 || !e.Events[1].JustPosted {
+		t.Fatalf("Unexpected events %+v", e.Events)
+	}
+
+	client.Social.HandlePacket(clanState)
+	nextEvent(t, client)
+	if len(client.events) != 0 {
+		t.Fatal("Expected no ClanEventsEvent for an unrequested clan state")
+	}
+
+	client.Social.RequestClanEvents(clan)
+	nextWritten(t, client)
+	client.Social.clanEventRequests[clan] = time.Now().Add(-2 * clanEventsTimeout)
+	client.Social.HandlePacket(clanState)
+	nextEvent(t, client)
+	if len(client.events) != 0 {
+		t.Fatal("Expected no ClanEventsEvent for an expired request")
+	}
+	client.Social.RequestClanEvents(clan)
+	nextWritten(t, client)
+	client.Disconnect()
+	if len(client.Social.clanEventRequests) != 0 {
+		t.Fatal("Expected pending clan event requests to be cleared on disconnect")
+	}
+}
+
+func TestFriendGameChange(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Relationship: EFriendRelationship_Friend})
+	playing := func(appId uint32, name string) {
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+			StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_GameExtraInfo)),
+			Friends: []*CMsgClientPersonaState_Friend{{
+				Friendid:        proto.Uint64(friend.ToUint64()),
+				GamePlayedAppId: proto.Uint32(appId),
+				GameName:        proto.String(name),
+			}},
+		}))
+		nextEvent(t, client) // PersonaStateEvent
+	}
+
+	tests := []struct {
+		appId    uint32
+		name     string
+		oldAppId uint32
+		started  bool
+		stopped  bool
+	}{
+		{440, "Team Fortress 2", 0, true, false},
+		{570, "Dota 2", 440, false, false},
+		{0, "", 570, false, true},
+	}
+	for _, test := range tests {
+		playing(test.appId, test.name)
+		e, ok := nextEvent(t, client).(*FriendGameChangeEvent)
+		if !ok {
+			t.Fatalf("Expected a FriendGameChangeEvent for %d, got %#v", test.appId, e)
+		}
+		if e.FriendId != friend || e.OldAppId != test.oldAppId || e.NewAppId != test.appId || e.GameName != test.name {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+		if e.Started() != test.started || e.Stopped() != test.stopped {
+			t.Fatalf("%+v: expected started %v and stopped %v", e, test.started, test.stopped)
+		}
+	}
+
+	playing(0, "")
+	if len(client.events) != 0 {
+		t.Fatal("Expected no FriendGameChangeEvent without a change")
+	}
+}
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestAutoAway(t *testing.T) {
+	client := newTestClient()
+	client.Social.SetPersonaState(EPersonaState_Busy)
+	nextWritten(t, client)
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := newAutoAway(client.Social, 5*time.Minute, 15*time.Minute, clock.Now)
+	client.Social.enableAutoAway(a, time.Hour)
+	expectState := func(state EPersonaState) {
+		t.Helper()
+		body := new(CMsgClientChangeStatus)
+		toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+		if EPersonaState(body.GetPersonaState()) != state {
+			t.Fatalf("Expected persona state %v, got %v", state, EPersonaState(body.GetPersonaState()))
+		}
+	}
+
+	clock.Advance(4 * time.Minute)
+	a.tick()
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no change before awayAfter")
+	}
+	clock.Advance(time.Minute)
+	a.tick()
+	expectState(EPersonaState_Away)
+	a.tick()
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected Away to be sent only once")
+	}
+	clock.Advance(10 * time.Minute)
+	a.tick()
+	expectState(EPersonaState_Snooze)
+
+	client.Social.NotifyActivity()
+	expectState(EPersonaState_Busy)
+	clock.Advance(5 * time.Minute)
+	a.tick()
+	expectState(EPersonaState_Away)
+
+	client.Social.DisableAutoAway()
+	select {
+	case <-a.done:
+	default:
+		t.Fatal("Expected the auto-away goroutine to have stopped")
+	}
+	client.Social.NotifyActivity()
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no state changes after disabling auto-away")
+	}
+	client.Social.DisableAutoAway()
+}
+
+func TestClose(t *testing.T) {
+	client := newTestClient()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := newAutoAway(client.Social, time.Minute, 0, clock.Now)
+	client.Social.enableAutoAway(a, time.Hour)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Social.GetPersona(context.Background(), steamid.NewIndividual(2), EClientPersonaStateFlag_DefaultInfoRequest)
+		result <- err
+	}()
+	select {
+	case <-client.writeChan: // the persona request, sent after the waiter is registered
+	case <-time.After(time.Second):
+		t.Fatal("Expected a persona request")
+	}
+
+	client.Social.Close()
+	select {
+	case err := <-result:
+		if err != ErrSocialClosed {
+			t.Fatalf("Expected ErrSocialClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pending request to be cancelled")
+	}
+	select {
+	case <-a.done:
+	default:
+		t.Fatal("Expected the auto-away goroutine to have stopped")
+	}
+
+	if _, err := client.Social.GetProfileInfo(context.Background(), steamid.NewIndividual(2)); err != ErrSocialClosed {
+		t.Fatalf("Expected ErrSocialClosed after Close, got %v", err)
+	}
+	client.Social.EnableAutoAway(time.Minute, 0)
+	client.Social.mutex.RLock()
+	restarted := client.Social.autoAway != nil
+	client.Social.mutex.RUnlock()
+	if restarted {
+		t.Fatal("Expected auto-away not to start after Close")
+	}
+	client.Social.Close()
+}
+
+func TestChatMsgTimestamps(t *testing.T) {
+	client := newTestClient()
+	before := time.Now()
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMsg{
+		SteamIdChatter:  SteamId(steamid.NewIndividual(2)),
+		SteamIdChatRoom: SteamId(steamid.NewChat(3)),
+		ChatMsgType:     EChatEntryType_ChatMsg,
+	}, []byte("hello\x00"))))
+	e := nextEvent(t, client).(*ChatMsgEvent)
+	if e.Timestamp.Before(before) || e.ServerTimestamp {
+		t.Fatalf("Expected a local timestamp for a room message, got %v (server %v)", e.Timestamp, e.ServerTimestamp)
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:            proto.Uint64(steamid.NewIndividual(2).ToUint64()),
+		ChatEntryType:          proto.Int32(int32(EChatEntryType_ChatMsg)),
+		Message:                []byte("hello\x00"),
+		Rtime32ServerTimestamp: proto.Uint32(1000),
+	}))
+	e = nextEvent(t, client).(*ChatMsgEvent)
+	if !e.Timestamp.Equal(time.Unix(1000, 0)) || !e.ServerTimestamp {
+		t.Fatalf("Expected the server timestamp for a friend message, got %v (server %v)", e.Timestamp, e.ServerTimestamp)
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:   proto.Uint64(steamid.NewIndividual(2).ToUint64()),
+		ChatEntryType: proto.Int32(int32(EChatEntryType_ChatMsg)),
+		Message:       []byte("hello\x00"),
+	}))
+	e = nextEvent(t, client).(*ChatMsgEvent)
+	if e.Timestamp.Before(before) || e.ServerTimestamp {
+		t.Fatalf("Expected a local timestamp without a server timestamp, got %v", e.Timestamp)
+	}
+}
+
+func TestGetChatMemberName(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	stranger := steamid.NewIndividual(3)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Name: "friend", Relationship: EFriendRelationship_Friend})
+
+	if name := client.Social.GetChatMemberName(friend); name != "friend" {
+		t.Fatalf("Expected the cached name, got %q", name)
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no request for a cached name")
+	}
+
+	if name := client.Social.GetChatMemberName(stranger); name != "" {
+		t.Fatalf("Expected no name for an unknown member, got %q", name)
+	}
+	request := new(CMsgClientRequestFriendData)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(request)
+	if len(request.GetFriends()) != 1 || steamid.SteamId(request.GetFriends()[0]) != stranger ||
+		EClientPersonaStateFlag(request.GetPersonaStateRequested()) != EClientPersonaStateFlag_PlayerName {
+		t.Fatalf("Expected a name request for %v, got %v", stranger, request)
+	}
+	client.Social.GetChatMemberName(stranger)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no second request while the first is pending")
+	}
+
+	chatId := steamid.NewChat(3)
+	member := steamid.NewIndividual(4)
+	client.Social.Chats.Add(socialcache.Chat{SteamId: chatId, Joined: true})
+	client.Social.Chats.AddChatMember(chatId, socialcache.ChatMember{SteamId: member, Name: "member"})
+	if name := client.Social.GetChatMemberName(member); name != "member" {
+		t.Fatalf("Expected the cached chat member name, got %q", name)
+	}
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no request for a cached chat member name")
+	}
+}
+
+func TestChatMemberPermissionsChange(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	member := steamid.NewIndividual(2)
+	client.Social.Chats.Add(socialcache.Chat{SteamId: chatId, Joined: true})
+	client.Social.Chats.AddChatMember(chatId, socialcache.ChatMember{
+		SteamId:         member,
+		ChatPermissions: EChatPermission_Talk,
+		ClanPermissions: EClanPermission_Member,
+	})
+
+	payload := new(bytes.Buffer)
+	writeChatMember(payload, member, "", EChatPermission_Talk|EChatPermission_Kick|EChatPermission_Ban, EClanPermission_Moderator)
+	payload.Write(make([]byte, 5))
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_InfoUpdate,
+	}, payload.Bytes())))
+
+	e, ok := nextEvent(t, client).(*ChatMemberPermissionsEvent)
+	if !ok || e.ChatRoomId != chatId || e.MemberId != member || e.ClanPermissions != EClanPermission_Moderator {
+		t.Fatalf("Unexpected event %#v", e)
+	}
+	chatPerm, clanPerm, err := client.Social.Chats.GetMemberPermissions(chatId, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chatPerm != EChatPermission_Talk|EChatPermission_Kick|EChatPermission_Ban || clanPerm != EClanPermission_Moderator {
+		t.Fatalf("Expected the cached permissions to be updated, got %v, %v", chatPerm, clanPerm)
+	}
+}
+
+func TestChatMemberVoice(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	member := steamid.NewIndividual(2)
+	client.Social.Chats.AddChatMember(chatId, socialcache.ChatMember{SteamId: member})
+
+	for _, state := range []EChatMemberStateChange{EChatMemberStateChange_VoiceSpeaking, EChatMemberStateChange_VoiceDoneSpeaking} {
+		payload := new(bytes.Buffer)
+		binary.Write(payload, binary.LittleEndian, member.ToUint64())
+		binary.Write(payload, binary.LittleEndian, int32(state))
+		binary.Write(payload, binary.LittleEndian, member.ToUint64())
+		payload.WriteByte(0)
+		client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+			SteamIdChat: SteamId(chatId),
+			Type:        EChatInfoType_StateChange,
+		}, payload.Bytes())))
+
+		nextEvent(t, client) // ChatMemberInfoEvent
+		e, ok := nextEvent(t, client).(*ChatMemberVoiceEvent)
+		speaking := state == EChatMemberStateChange_VoiceSpeaking
+		if !ok || e.ChatRoomId != chatId || e.MemberId != member || e.Speaking != speaking {
+			t.Fatalf("Unexpected event %#v", e)
+		}
+		if members, _ := client.Social.Chats.GetMembers(chatId); len(members) != 1 {
+			t.Fatalf("Expected the membership to be unchanged, got %+v", members)
+		}
+	}
+}
+
+func TestJoinChatWait(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	join := func(response EChatRoomEnterResponse) (*ChatEnterEvent, error) {
+		type result struct {
+			event *ChatEnterEvent
+			err   error
+		}
+		results := make(chan result, 1)
+		go func() {
+			event, err := client.Social.JoinChatWait(context.Background(), chatId)
+			results <- result{event, err}
+		}()
+		select {
+		case <-client.writeChan: // the join request, sent after the waiter is registered
+		case <-time.After(time.Second):
+			t.Fatal("Expected a join request")
+		}
+		client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+			SteamIdChat:   SteamId(chatId),
+			EnterResponse: response,
+		}, []byte("room\x00\x00"))))
+		select {
+		case r := <-results:
+			return r.event, r.err
+		case <-time.After(time.Second):
+			t.Fatal("Expected JoinChatWait to return")
+			return nil, nil
+		}
+	}
+
+	event, err := join(EChatRoomEnterResponse_Success)
+	if err != nil || event == nil || event.ChatRoomId != chatId || event.Name != "room" {
+		t.Fatalf("Expected a successful enter, got %+v (%v)", event, err)
+	}
+
+	event, err = join(EChatRoomEnterResponse_Banned)
+	if err == nil || event == nil || event.EnterResponse != EChatRoomEnterResponse_Banned {
+		t.Fatalf("Expected a failed enter, got %+v (%v)", event, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Social.JoinChatWait(ctx, chatId); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline exceeded error, got %v", err)
+	}
+}
+
+func TestSetPersonaStateInvisible(t *testing.T) {
+	client := newTestClient()
+	client.Social.SetPersonaState(EPersonaState_Invisible)
+
+	body := new(CMsgClientChangeStatus)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	if body.PersonaState == nil || body.GetPersonaState() != 7 {
+		t.Fatalf("Expected persona state 7, got %v", body.PersonaState)
+	}
+	if body.PlayerName != nil || body.PersonaStateFlags != nil {
+		t.Fatalf("Expected only the persona state to be sent, got %v", body)
+	}
+	if client.Social.GetPersonaState() != EPersonaState_Invisible {
+		t.Fatalf("Expected Invisible to be cached, got %v", client.Social.GetPersonaState())
+	}
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := newAutoAway(client.Social, time.Minute, 0, clock.Now)
+	client.Social.enableAutoAway(a, time.Hour)
+	defer client.Social.DisableAutoAway()
+	clock.Advance(time.Hour)
+	a.tick()
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected auto-away not to reveal an invisible user")
+	}
+}
+
+func TestSetPersonaStateUnchanged(t *testing.T) {
+	client := newTestClient()
+	client.Social.SetPersonaState(EPersonaState_Online)
+	nextWritten(t, client)
+
+	client.Social.SetPersonaState(EPersonaState_Online)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected no message for an unchanged persona state")
+	}
+
+	client.Social.ForceSetPersonaState(EPersonaState_Online)
+	body := new(CMsgClientChangeStatus)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+	if EPersonaState(body.GetPersonaState()) != EPersonaState_Online {
+		t.Fatalf("Expected a forced Online state, got %v", EPersonaState(body.GetPersonaState()))
+	}
+
+	// a new session starts offline, so the state must be sent again
+	atomic.StoreInt32(&client.sessionId, 2)
+	client.Social.SetPersonaState(EPersonaState_Online)
+	nextWritten(t, client)
+}
+
+// redirectTransport sends all requests to a test server
+type redirectTransport struct {
+	server *httptest.Server
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(t.server.URL)
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetchAvatar(t *testing.T) {
+	var requests []string
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.URL.Path)
+		mutex.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/huge") {
+			w.Write(make([]byte, maxAvatarSize+1))
+			return
+		}
+		w.Write([]byte("image " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	client := newTestClient()
+	client.Social.avatarClient = &http.Client{Transport: redirectTransport{server}}
+	const avatar = "abcdef0123456789abcdef0123456789abcdef01"
+	friend := steamid.NewIndividual(2)
+	noAvatar := steamid.NewIndividual(3)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Avatar: avatar})
+	client.Social.Friends.Add(socialcache.Friend{SteamId: noAvatar})
+
+	for i := 0; i < 2; i++ {
+		data, err := client.Social.FetchAvatar(context.Background(), friend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "image /"+avatar+"_full.jpg" {
+			t.Fatalf("Unexpected image %q", data)
+		}
+	}
+	data, err := client.Social.FetchAvatar(context.Background(), noAvatar)
+	if err != nil || string(data) != "image /"+DefaultAvatar+"_full.jpg" {
+		t.Fatalf("Expected the default avatar, got %q, %v", data, err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected the cached avatar not to be downloaded again, got requests %v", requests)
+	}
+
+	if _, err := client.Social.FetchAvatar(context.Background(), steamid.NewIndividual(4)); err == nil {
+		t.Fatal("Expected an error for an unknown friend")
+	}
+	client.Social.Friends.SetAvatar(friend, "missing00000000000000000000000000000000")
+	if _, err := client.Social.FetchAvatar(context.Background(), friend); err == nil {
+		t.Fatal("Expected an error for a failed download")
+	}
+	client.Social.Friends.SetAvatar(friend, "huge000000000000000000000000000000000000")
+	if _, err := client.Social.FetchAvatar(context.Background(), friend); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("Expected an error for an oversized avatar, got %v", err)
+	}
+}
+
+func TestChatFlood(t *testing.T) {
+	client := newTestClient()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	client.Social.chatFlood = newChatFlood(3, 10*time.Second, clock.Now)
+	room := steamid.NewChat(3)
+	spammer := steamid.NewIndividual(2)
+	other := steamid.NewIndividual(4)
+	send := func(chatter steamid.SteamId) *ChatFloodEvent {
+		client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMsg{
+			ChatMsgType:     EChatEntryType_ChatMsg,
+			SteamIdChatRoom: SteamId(room),
+			SteamIdChatter:  SteamId(chatter),
+		}, []byte("spam\x00"))))
+		nextEvent(t, client) // ChatMsgEvent
+		if len(client.events) == 0 {
+			return nil
+		}
+		return nextEvent(t, client).(*ChatFloodEvent)
+	}
+
+	// slow enough to stay below the threshold
+	for i := 0; i < 6; i++ {
+		if e := send(spammer); e != nil {
+			t.Fatalf("Unexpected flood event %+v", e)
+		}
+		clock.Advance(4 * time.Second)
+	}
+
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		if e := send(spammer); e != nil {
+			t.Fatalf("Unexpected flood event %+v", e)
+		}
+		if e := send(other); e != nil {
+			t.Fatalf("Expected members to be counted separately, got %+v", e)
+		}
+	}
+	e := send(spammer)
+	if e == nil || e.ChatRoomId != room || e.ChatterId != spammer || e.Count != 4 {
+		t.Fatalf("Expected a flood event, got %+v", e)
+	}
+	if e := send(spammer); e != nil {
+		t.Fatalf("Expected a single flood event, got %+v", e)
+	}
+
+	client.Social.SetChatFloodThreshold(0, 0)
+	if client.Social.chatFlood != nil {
+		t.Fatal("Expected flood detection to be disabled")
+	}
+}
+
+func TestGameServerAddr(t *testing.T) {
+	event := &PersonaStateEvent{GameServerIp: 0xD1C51DC4, GameServerPort: 27015}
+	if addr := event.GameServerAddr(); addr != "209.197.29.196:27015" {
+		t.Fatalf("Expected 209.197.29.196:27015, got %q", addr)
+	}
+	if addr := (&PersonaStateEvent{}).GameServerAddr(); addr != "" {
+		t.Fatalf("Expected no address without a server, got %q", addr)
+	}
+}
+
+func TestRegisterPacketHandler(t *testing.T) {
+	client := newTestClient()
+	var calls []string
+	client.Social.RegisterPacketHandler(EMsg_ClientIsLimitedAccount, func(packet *Packet) {
+		calls = append(calls, "first")
+	})
+	unregister := client.Social.RegisterPacketHandler(EMsg_ClientIsLimitedAccount, func(packet *Packet) {
+		calls = append(calls, "second")
+	})
+	client.Social.RegisterPacketHandler(EMsg_ClientFriendsList, func(packet *Packet) {
+		calls = append(calls, "friends")
+	})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientIsLimitedAccount, &CMsgClientIsLimitedAccount{}))
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Fatalf("Expected both handlers in registration order, got %v", calls)
+	}
+
+	unregister()
+	calls = nil
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientIsLimitedAccount, &CMsgClientIsLimitedAccount{}))
+	if len(calls) != 1 || calls[0] != "first" {
+		t.Fatalf("Expected only the remaining handler, got %v", calls)
+	}
+
+	// custom handlers run after the built-in one
+	calls = nil
+	friend := steamid.NewIndividual(2)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsList, &CMsgClientFriendsList{
+		Friends: []*CMsgClientFriendsList_Friend{
+			{Ulfriendid: proto.Uint64(friend.ToUint64()), Efriendrelationship: proto.Uint32(uint32(EFriendRelationship_Friend))},
+		},
+	}))
+	if len(calls) != 1 {
+		t.Fatalf("Expected the custom handler to run, got %v", calls)
+	}
+	if _, err := client.Social.Friends.ById(friend); err != nil {
+		t.Fatal("Expected the built-in handler to run as well")
+	}
+}
+
+func TestMalformedChatPackets(t *testing.T) {
+	client := newTestClient()
+	chatId := steamid.NewChat(3)
+	member := new(bytes.Buffer)
+	writeChatMember(member, steamid.NewIndividual(2), "Gabe", EChatPermission_Talk, 0)
+	truncatedMember := member.Bytes()[:member.Len()-3]
+
+	expectParseError := func(emsg EMsg) {
+		t.Helper()
+		e, ok := nextEvent(t, client).(*ParseErrorEvent)
+		if !ok || e.EMsg != emsg || e.Err == nil {
+			t.Fatalf("Expected a ParseErrorEvent for %v, got %#v", emsg, e)
+		}
+		if len(client.events) != 0 {
+			t.Fatalf("Expected no other events, got %#v", <-client.events)
+		}
+	}
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Success,
+		NumMembers:    2,
+	}, append([]byte("room\x00\x00"), truncatedMember...))))
+	expectParseError(EMsg_ClientChatEnter)
+	if _, err := client.Social.Chats.ById(chatId); err == nil {
+		t.Fatal("Expected the chat not to be cached")
+	}
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_StateChange,
+	}, []byte{1, 2, 3})))
+	expectParseError(EMsg_ClientChatMemberInfo)
+
+	stateChange := new(bytes.Buffer)
+	binary.Write(stateChange, binary.LittleEndian, steamid.NewIndividual(2).ToUint64())
+	binary.Write(stateChange, binary.LittleEndian, int32(EChatMemberStateChange_Entered))
+	binary.Write(stateChange, binary.LittleEndian, steamid.NewIndividual(2).ToUint64())
+	stateChange.WriteByte(0)
+	stateChange.Write(truncatedMember)
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_StateChange,
+	}, stateChange.Bytes())))
+	expectParseError(EMsg_ClientChatMemberInfo)
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatMemberInfo{
+		SteamIdChat: SteamId(chatId),
+		Type:        EChatInfoType_InfoUpdate,
+	}, []byte("MessageObject\x00\x09key\x00"))))
+	expectParseError(EMsg_ClientChatMemberInfo)
+
+	client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatEnter{
+		SteamIdChat:   SteamId(chatId),
+		EnterResponse: EChatRoomEnterResponse_Success,
+	}, bytes.Repeat([]byte("a"), 1<<16))))
+	expectParseError(EMsg_ClientChatEnter)
+
+	for _, truncated := range [][]byte{{1, 2}, {1, 2, 3, 4, 5}} {
+		client.Social.HandlePacket(toPacket(t, NewClientMsg(&MsgClientChatRoomInfo{
+			SteamIdChat: SteamId(chatId),
+			Type:        EChatInfoType_InfoUpdate,
+		}, truncated)))
+		expectParseError(EMsg_ClientChatRoomInfo)
+	}
+
+	if members, _ := client.Social.Chats.GetMembers(chatId); len(members) != 0 {
+		t.Fatalf("Expected no members to be cached, got %+v", members)
+	}
+}
+
+func TestSendMessageWithId(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	clan := steamid.NewClan(4)
+	for i, to := range []steamid.SteamId{friend, clan, friend} {
+		id, err := client.Social.SendMessageWithId(to, EChatEntryType_ChatMsg, "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != uint64(i+1) {
+			t.Fatalf("Expected message id %d, got %d", i+1, id)
+		}
+	}
+	if _, err := client.Social.SendMessageWithId(steamid.SteamId(0), EChatEntryType_ChatMsg, "hi"); err == nil {
+		t.Fatal("Expected an error for an invalid recipient")
+	}
+	if len(client.events) != 0 {
+		t.Fatal("Expected no MessageSentEvent before the messages are written")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.writeLoop()
+		close(done)
+	}()
+	close(client.writeChan)
+	<-done
+	for i, to := range []steamid.SteamId{friend, clan.ClanToChat(), friend} {
+		var event interface{}
+		select {
+		case event = <-client.events:
+		case <-time.After(time.Second):
+			t.Fatal("Expected a MessageSentEvent")
+		}
+		e, ok := event.(*MessageSentEvent)
+		if !ok || e.Id != uint64(i+1) || e.RecipientId != to || e.Message != "hi" {
+			t.Fatalf("Unexpected event %#v", e)
+		}
+	}
+}
+
+func TestChatEnterFlags(t *testing.T) {
+	clan := steamid.NewClan(4)
+	for _, test := range []struct {
+		flags                           byte
+		clanId                          steamid.SteamId
+		locked, officersOnly, moderated bool
+	}{
+		{0, clan, false, false, false},
+		{byte(EChatFlags_Locked), clan, true, true, false},
+		{byte(EChatFlags_Locked), 0, true, false, false},
+		{byte(EChatFlags_Moderated), clan, false, false, true},
+		{byte(EChatFlags_Locked | EChatFlags_Moderated | EChatFlags_Unjoinable), clan, true, true, true},
+		{byte(EChatFlags_InvisibleToFriends), clan, false, false, false},
+	} {
+		e := &ChatEnterEvent{ChatFlags: test.flags, ClanId: test.clanId}
+		if e.IsLocked() != test.locked || e.IsOfficersOnly() != test.officersOnly || e.IsModerated() != test.moderated {
+			t.Fatalf("Flags %#x in clan %v: got locked %v, officers only %v, moderated %v", test.flags, test.clanId,
+				e.IsLocked(), e.IsOfficersOnly(), e.IsModerated())
+		}
+	}
+}
+
+func TestSentNotifyDoesNotBlockWrites(t *testing.T) {
+	client := newTestClient()
+	for len(client.events) < cap(client.events) {
+		client.Emit(nil)
+	}
+	for i := 0; i < 3; i++ {
+		if err := client.Social.SendMessage(steamid.NewIndividual(2), EChatEntryType_ChatMsg, "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.writeLoop()
+		close(done)
+	}()
+	close(client.writeChan)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the write loop not to block on a full event channel")
+	}
+	for len(client.events) > 0 {
+		<-client.events
+	}
+}
+
+func TestEmitSentMessages(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	clan := steamid.NewClan(4)
+	// write calls the sent notifications of the queued messages like the write loop does
+	write := func() []*ChatMsgEvent {
+		var sent []*ChatMsgEvent
+		for len(client.writeChan) > 0 {
+			nextWritten(t, client).(*sentNotifyMsg).onSent()
+			for len(client.events) > 0 {
+				if e, ok := (<-client.events).(*ChatMsgEvent); ok {
+					sent = append(sent, e)
+				}
+			}
+		}
+		return sent
+	}
+	if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "quiet"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := write(); len(sent) != 0 {
+		t.Fatalf("Expected no ChatMsgEvent for sent messages by default, got %v", sent)
+	}
+
+	client.Social.EmitSentMessages(true)
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "hi")
+	client.Social.SendMessage(clan, EChatEntryType_Emote, "waves")
+	if len(client.events) != 0 {
+		t.Fatal("Expected no ChatMsgEvent before the messages are written")
+	}
+	sent := write()
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 ChatMsgEvents, got %v", sent)
+	}
+	if e := sent[0]; !e.Outgoing || e.ChatterId != SteamId(testSelfId) || e.RecipientId != SteamId(friend) ||
+		e.ChatRoomId != 0 || e.Message != "hi" {
+		t.Fatalf("Unexpected event %#v", e)
+	}
+	if e := sent[1]; !e.Outgoing || e.ChatRoomId != SteamId(clan.ClanToChat()) || e.RecipientId != 0 || e.EntryType != EChatEntryType_Emote {
+		t.Fatalf("Unexpected event %#v", e)
+	}
+
+	client.Social.EnableSendQueue(1)
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "queued")
+	if len(client.events) != 0 || len(client.writeChan) != 0 {
+		t.Fatal("Expected no ChatMsgEvent for a queued message")
+	}
+	client.Social.EnableSendQueue(0)
+
+	client.Social.EmitSentMessages(false)
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "bye")
+	if sent := write(); len(sent) != 0 {
+		t.Fatalf("Expected no ChatMsgEvent after disabling, got %v", sent)
+	}
+}
+
+func TestJoinLeaveGroup(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(4)
+	client.Social.Groups.Add(socialcache.Group{SteamId: clan, Name: "clan", Relationship: EClanRelationship_Invited})
+	groupState := func(rel EClanRelationship) {
+		t.Helper()
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendsList, &CMsgClientFriendsList{
+			Bincremental: proto.Bool(true),
+			Friends: []*CMsgClientFriendsList_Friend{
+				{Ulfriendid: proto.Uint64(clan.ToUint64()), Efriendrelationship: proto.Uint32(uint32(rel))},
+			},
+		}))
+		e, ok := nextEvent(t, client).(*GroupStateEvent)
+		if !ok || e.SteamId != clan || e.Relationship != rel {
+			t.Fatalf("Unexpected event %#v", e)
+		}
+	}
+
+	client.Social.JoinGroup(clan.ClanToChat())
+	body := new(MsgClientAcknowledgeClanInvite)
+	toPacket(t, nextWritten(t, client)).ReadClientMsg(body)
+	if steamid.SteamId(body.SteamIdClan) != clan || !body.AcceptInvite {
+		t.Fatalf("Unexpected message %+v", body)
+	}
+	groupState(EClanRelationship_Member)
+	if group, _ := client.Social.Groups.ById(clan); group.Relationship != EClanRelationship_Member {
+		t.Fatalf("Expected the relationship to be Member, got %v", group.Relationship)
+	}
+
+	client.Social.LeaveGroup(clan)
+	remove := new(CMsgClientRemoveFriend)
+	toPacket(t, nextWritten(t, client)).ReadProtoMsg(remove)
+	if steamid.SteamId(remove.GetFriendid()) != clan {
+		t.Fatalf("Expected the clan to be removed, got %v", remove.GetFriendid())
+	}
+	groupState(EClanRelationship_None)
+	if _, err := client.Social.Groups.ById(clan); err == nil {
+		t.Fatal("Expected the group to be removed from the cache")
+	}
+}
+
+func TestInviteToGroup(t *testing.T) {
+	client := newTestClient()
+	clan := steamid.NewClan(4)
+	friend := steamid.NewIndividual(2)
+	client.Social.InviteToGroup(clan.ClanToChat(), friend)
+
+	body := new(MsgClientInviteUserToClan)
+	request := toPacket(t, nextWritten(t, client))
+	request.ReadClientMsg(body)
+	if steamid.SteamId(body.SteamIdClan) != clan || steamid.SteamId(body.SteamIdInvitee) != friend || body.OnlyOfficerCanInviteMe {
+		t.Fatalf("Unexpected message %+v", body)
+	}
+
+	other := NewClientMsgProtobuf(EMsg_ClientFriendsList, &CMsgClientFriendsList{})
+	client.Social.HandlePacket(toPacket(t, other))
+	nextEvent(t, client) // FriendsListEvent of an unrelated response
+	other.SetTargetJobId(request.SourceJobId)
+	client.Social.HandlePacket(toPacket(t, other))
+	if _, ok := nextEvent(t, client).(*FriendsListEvent); !ok {
+		t.Fatal("Expected a packet of another type with the same job id to be handled as usual")
+	}
+
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, int32(EResult_AccessDenied))
+	response := NewClientMsg(&MsgClientInviteUserToClan{}, nil)
+	response.SetTargetJobId(request.SourceJobId)
+	data := new(bytes.Buffer)
+	response.Header.Serialize(data)
+	data.Write(payload.Bytes())
+	packet, err := NewPacket(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Social.HandlePacket(packet)
+	e, ok := nextEvent(t, client).(*GroupInviteResultEvent)
+	if !ok || e.ClanId != clan || e.InviteeId != friend || e.Result != EResult_AccessDenied {
+		t.Fatalf("Unexpected event %#v", e)
+	}
+
+	client.Social.InviteToGroup(clan, friend)
+	nextWritten(t, client)
+	client.Disconnect()
+	if len(client.Social.groupInvites) != 0 {
+		t.Fatal("Expected pending invites to be cleared on disconnect")
+	}
+}
+
+func TestPersonaStateSourceSteamId(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	group := steamid.NewClan(4)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: friend, Relationship: EFriendRelationship_Friend})
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_SourceID)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(friend.ToUint64()), SteamidSource: proto.Uint64(group.ToUint64())},
+		},
+	}))
+	if source, _ := client.Social.Friends.GetSourceSteamId(friend); source != group {
+		t.Fatalf("Expected source %v, got %v", group, source)
+	}
+}
+
+func TestNicknameList(t *testing.T) {
+	client := newTestClient()
+	a, b, c := steamid.NewIndividual(2), steamid.NewIndividual(3), steamid.NewIndividual(4)
+	for _, id := range []steamid.SteamId{a, b, c} {
+		client.Social.Friends.Add(socialcache.Friend{SteamId: id, Relationship: EFriendRelationship_Friend})
+	}
+	client.Social.Friends.SetNickname(c, "stale")
+	nicknameList := func(list *CMsgClientPlayerNicknameList) *NicknameListEvent {
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPlayerNicknameList, list))
+		return nextEvent(t, client).(*NicknameListEvent)
+	}
+	expectNicknames := func(expected map[steamid.SteamId]string) {
+		t.Helper()
+		for id, nickname := range expected {
+			if friend, _ := client.Social.Friends.ById(id); friend.Nickname != nickname {
+				t.Fatalf("%v: expected nickname %q, got %q", id, nickname, friend.Nickname)
+			}
+		}
+	}
+
+	e := nicknameList(&CMsgClientPlayerNicknameList{
+		Nicknames: []*CMsgClientPlayerNicknameList_PlayerNickname{
+			{Steamid: proto.Uint64(a.ToUint64()), Nickname: proto.String("Alice")},
+			{Steamid: proto.Uint64(b.ToUint64()), Nickname: proto.String("Bob")},
+		},
+	})
+	if e.Incremental || len(e.Nicknames) != 2 || e.Nicknames[a] != "Alice" {
+		t.Fatalf("Unexpected event %+v", e)
+	}
+	expectNicknames(map[steamid.SteamId]string{a: "Alice", b: "Bob", c: ""})
+
+	nicknameList(&CMsgClientPlayerNicknameList{
+		Incremental: proto.Bool(true),
+		Removal:     proto.Bool(true),
+		Nicknames: []*CMsgClientPlayerNicknameList_PlayerNickname{
+			{Steamid: proto.Uint64(b.ToUint64())},
+		},
+	})
+	expectNicknames(map[steamid.SteamId]string{a: "Alice", b: ""})
+}
+
+func TestClearCache(t *testing.T) {
+	client := newTestClient()
+	group := steamid.NewClan(5)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: steamid.NewIndividual(2)})
+	client.Social.Groups.Add(socialcache.Group{SteamId: group})
+	client.Social.Chats.Add(socialcache.Chat{SteamId: group.ClanToChat()})
+	client.Social.Categories.Add(socialcache.Category{Id: 1, Name: "category"})
+	client.Social.ChatRoomGroups.Add(socialcache.ChatRoomGroup{SteamId: group.ClanToChat(), GroupId: 1})
+
+	client.Social.ClearCache()
+	if client.Social.Friends.Count() != 0 || client.Social.Groups.Count() != 0 || client.Social.Chats.Count() != 0 {
+		t.Fatalf("Expected empty caches, got %d friends, %d groups and %d chats",
+			client.Social.Friends.Count(), client.Social.Groups.Count(), client.Social.Chats.Count())
+	}
+	if client.Social.Categories.Count() != 0 || client.Social.ChatRoomGroups.Count() != 0 {
+		t.Fatalf("Expected no categories and chat room groups, got %d and %d",
+			client.Social.Categories.Count(), client.Social.ChatRoomGroups.Count())
+	}
+}
+
+func TestUseNewChatRooms(t *testing.T) {
+	client := newTestClient()
+	room := steamid.NewClan(5).ClanToChat()
+	client.Social.SetChatRoomChannel(room, 7, 9)
+	sendType := func(to steamid.SteamId, entryType EChatEntryType) IClientMsg {
+		t.Helper()
+		if err := client.Social.SendMessage(to, entryType, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		return nextWritten(t, client).(*sentNotifyMsg).IClientMsg
+	}
+
+	if msg := sendType(room, EChatEntryType_ChatMsg); msg.GetMsgType() != EMsg_ClientChatMsg {
+		t.Fatalf("Expected a legacy chat message by default, got %v", msg.GetMsgType())
+	}
+
+	client.Social.UseNewChatRooms(true)
+	msg, ok := sendType(room.ChatToClan(), EChatEntryType_ChatMsg).(*ClientMsgProtobuf)
+	if !ok || msg.GetMsgType() != EMsg_ClientServiceMethod {
+		t.Fatalf("Expected a ClientServiceMethod message, got %#v", msg)
+	}
+	body := msg.Body.(*CMsgClientServiceMethod)
+	if body.GetMethodName() != "ChatRoom.SendChatMessage#1" {
+		t.Fatalf("Unexpected service method %v", body)
+	}
+	var groupId, chatId uint64
+	var text string
+	for raw := body.GetSerializedMethod(); len(raw) > 0; {
+		num, typ, n := protowire.ConsumeTag(raw)
+		raw = raw[n:]
+		switch num {
+		case 1:
+			groupId, n = protowire.ConsumeVarint(raw)
+		case 2:
+			chatId, n = protowire.ConsumeVarint(raw)
+		case 3:
+			text, n = protowire.ConsumeString(raw)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, raw)
+		}
+		raw = raw[n:]
+	}
+	if groupId != 7 || chatId != 9 || text != "hello" {
+		t.Fatalf("Unexpected request %d, %d, %q", groupId, chatId, text)
+	}
+
+	if msg := sendType(room, EChatEntryType_Emote); msg.GetMsgType() != EMsg_ClientChatMsg {
+		t.Fatalf("Expected emotes to be sent as legacy chat messages, got %v", msg.GetMsgType())
+	}
+	if msg := sendType(steamid.NewClan(6).ClanToChat(), EChatEntryType_ChatMsg); msg.GetMsgType() != EMsg_ClientChatMsg {
+		t.Fatalf("Expected a legacy chat message for an unknown room, got %v", msg.GetMsgType())
+	}
+}
+
+// newServiceMethodPacket returns a packet of a service method notification with the given raw body
+func newServiceMethodPacket(t *testing.T, method string, body []byte) *Packet {
+	t.Helper()
+	header := NewMsgHdrProtoBuf()
+	header.Msg = EMsg_ServiceMethod
+	header.Proto.TargetJobName = proto.String(method)
+	buf := new(bytes.Buffer)
+	if err := header.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(body)
+	packet, err := NewPacket(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestIncomingChatRoomMessage(t *testing.T) {
+	client := newTestClient()
+	// chat_group_id 4012345, chat_id 13456789, sent by account 2 at 1700000000 with ordinal 0 and bbcode
+	captured, _ := hex.DecodeString("08b9f2f4011095abb506190200000001001001221268656c6c6f205b625d776f726c645b2f625d" +
+		"2880e2cfaa0638004a0b68656c6c6f20776f726c64")
+	client.Social.HandlePacket(newServiceMethodPacket(t, "ChatRoomClient.NotifyIncomingChatMessage#1", captured))
+
+	event := nextEvent(t, client).(*ChatMsgEvent)
+	room := steamid.NewIdAdv(4012345, chatRoomGroupInstance, int32(EUniverse_Public), EAccountType_Chat)
+	if legacy := steamid.NewChat(4012345); room == legacy || room.IsChatClan() {
+		t.Fatalf("Expected the group's SteamId not to collide with legacy chat rooms, got %v", room)
+	}
+	if steamid.SteamId(event.ChatRoomId) != room || steamid.SteamId(event.ChatterId) != steamid.NewIndividual(2) {
+		t.Fatalf("Unexpected room %v or chatter %v", event.ChatRoomId, event.ChatterId)
+	}
+	if event.Message != "hello [b]world[/b]" || !event.IsMessage() {
+		t.Fatalf("Unexpected message %q of type %v", event.Message, event.EntryType)
+	}
+	if !event.ServerTimestamp || event.Timestamp.Unix() != 1700000000 {
+		t.Fatalf("Expected the server timestamp, got %v", event.Timestamp)
+	}
+
+	client.Social.UseNewChatRooms(true)
+	client.Social.SendMessage(room, EChatEntryType_ChatMsg, "reply")
+	if msg := nextWritten(t, client); msg.GetMsgType() != EMsg_ClientServiceMethod {
+		t.Fatalf("Expected the reply to go through the ChatRoom service, got %v", msg.GetMsgType())
+	}
+
+	clan := steamid.NewClan(5)
+	client.Social.SetChatRoomChannel(clan, 8, 1)
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.VarintType)
+	body = protowire.AppendVarint(body, 8)
+	body = protowire.AppendTag(body, 2, protowire.VarintType)
+	body = protowire.AppendVarint(body, 2)
+	body = protowire.AppendTag(body, 4, protowire.BytesType)
+	body = protowire.AppendString(body, "in another channel")
+	client.Social.HandlePacket(newServiceMethodPacket(t, "ChatRoomClient.NotifyIncomingChatMessage#1", body))
+	event = nextEvent(t, client).(*ChatMsgEvent)
+	if steamid.SteamId(event.ChatRoomId) != clan.ClanToChat() || event.ServerTimestamp {
+		t.Fatalf("Expected a message in %v without server timestamp, got %+v", clan.ClanToChat(), event)
+	}
+	if channel, _ := client.Social.chatRoomChannel(clan); channel.chatId != 1 {
+		t.Fatalf("Expected replies to go to the group's default channel, got %d", channel.chatId)
+	}
+
+	client.Social.HandlePacket(newServiceMethodPacket(t, "ChatRoomClient.NotifyIncomingChatMessage#1", []byte{0x0a, 0x05}))
+	if _, ok := nextEvent(t, client).(*ParseErrorEvent); !ok {
+		t.Fatal("Expected a ParseErrorEvent for a truncated message")
+	}
+}
+
+func TestChatRoomGroups(t *testing.T) {
+	client := newTestClient()
+	client.Social.RequestChatRoomGroups()
+	request := nextWritten(t, client).(*ClientMsgProtobuf)
+	if name := request.Body.(*CMsgClientServiceMethod).GetMethodName(); name != "ChatRoom.GetMyChatRoomGroups#1" {
+		t.Fatalf("Unexpected service method %q", name)
+	}
+
+	channel := func(chatId uint64, name string) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, chatId)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		return protowire.AppendString(b, name)
+	}
+	summaryPair := func(groupId, defaultChatId uint64, clanId uint32, name string, channels ...[]byte) []byte {
+		var summary []byte
+		summary = protowire.AppendTag(summary, 1, protowire.VarintType)
+		summary = protowire.AppendVarint(summary, groupId)
+		summary = protowire.AppendTag(summary, 2, protowire.BytesType)
+		summary = protowire.AppendString(summary, name)
+		summary = protowire.AppendTag(summary, 3, protowire.VarintType)
+		summary = protowire.AppendVarint(summary, 42)
+		summary = protowire.AppendTag(summary, 5, protowire.VarintType)
+		summary = protowire.AppendVarint(summary, defaultChatId)
+		for _, c := range channels {
+			summary = protowire.AppendTag(summary, 6, protowire.BytesType)
+			summary = protowire.AppendBytes(summary, c)
+		}
+		summary = protowire.AppendTag(summary, 7, protowire.VarintType)
+		summary = protowire.AppendVarint(summary, uint64(clanId))
+		var pair []byte
+		pair = protowire.AppendTag(pair, 2, protowire.BytesType)
+		pair = protowire.AppendBytes(pair, summary)
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		return protowire.AppendBytes(b, pair)
+	}
+	response := summaryPair(20, 200, 0, "Friends", channel(200, "General"))
+	response = append(response, summaryPair(10, 100, 5, "Clan", channel(100, "Lobby"), channel(101, "Off-topic"))...)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientServiceMethodResponse, &CMsgClientServiceMethodResponse{
+		MethodName:               proto.String("ChatRoom.GetMyChatRoomGroups#1"),
+		SerializedMethodResponse: response,
+	}))
+
+	event := nextEvent(t, client).(*ChatRoomGroupsEvent)
+	if len(event.Groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %+v", event.Groups)
+	}
+	groups := client.Social.GetChatRoomGroups()
+	if len(groups) != 2 || groups[0].GroupId != 10 || groups[1].GroupId != 20 {
+		t.Fatalf("Expected groups sorted by id, got %+v", groups)
+	}
+	clan := groups[0]
+	if clan.Name != "Clan" || clan.ClanId != steamid.NewClan(5) || clan.SteamId != steamid.NewClan(5).ClanToChat() {
+		t.Fatalf("Unexpected clan group %+v", clan)
+	}
+	if len(clan.Channels) != 2 || clan.Channels[1].ChatId != 101 || clan.Channels[1].Name != "Off-topic" {
+		t.Fatalf("Unexpected channels %+v", clan.Channels)
+	}
+	if groups[1].SteamId != steamid.NewIdAdv(20, chatRoomGroupInstance, int32(EUniverse_Public), EAccountType_Chat) || groups[1].ClanId != 0 {
+		t.Fatalf("Unexpected group %+v", groups[1])
+	}
+	if _, err := client.Social.ChatRoomGroups.ById(clan.SteamId); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Social.UseNewChatRooms(true)
+	if channel, ok := client.Social.chatRoomChannel(steamid.NewClan(5)); !ok || channel.groupId != 10 || channel.chatId != 100 {
+		t.Fatalf("Expected messages to the clan to go to its default channel, got %+v", channel)
+	}
+
+	// a group missing from a later response was left
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientServiceMethodResponse, &CMsgClientServiceMethodResponse{
+		MethodName:               proto.String("ChatRoom.GetMyChatRoomGroups#1"),
+		SerializedMethodResponse: summaryPair(20, 200, 0, "Friends", channel(200, "General")),
+	}))
+	nextEvent(t, client)
+	if groups := client.Social.GetChatRoomGroups(); len(groups) != 1 || groups[0].GroupId != 20 {
+		t.Fatalf("Expected only the remaining group, got %+v", groups)
+	}
+	if _, err := client.Social.ChatRoomGroups.ById(clan.SteamId); err == nil {
+		t.Fatal("Expected the left group to be removed")
+	}
+	if _, ok := client.Social.chatRoomChannel(steamid.NewClan(5)); ok {
+		t.Fatal("Expected no channel for the left group")
+	}
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientServiceMethodResponse, &CMsgClientServiceMethodResponse{
+		MethodName:               proto.String("ChatRoom.GetMyChatRoomGroups#1"),
+		SerializedMethodResponse: []byte{0x0a, 0x02, 0x12, 0x05},
+	}))
+	if _, ok := nextEvent(t, client).(*ParseErrorEvent); !ok {
+		t.Fatal("Expected a ParseErrorEvent for a truncated response")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	client := newTestClient()
+	a, b := steamid.NewIndividual(2), steamid.NewIndividual(3)
+	client.Social.Friends.Add(socialcache.Friend{SteamId: a, Relationship: EFriendRelationship_Friend})
+	client.Social.Friends.Add(socialcache.Friend{SteamId: b, Relationship: EFriendRelationship_Friend})
+	client.Social.SetEventFilter(func(event interface{}) bool {
+		switch e := event.(type) {
+		case *PersonaStateEvent:
+			return e.FriendId == a
+		case *ChatMsgEvent:
+			return steamid.SteamId(e.ChatterId) == a
+		}
+		return false
+	})
+	var subscribed []steamid.SteamId
+	client.Social.OnPersonaState(func(e *PersonaStateEvent) {
+		subscribed = append(subscribed, e.FriendId)
+	})
+
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientPersonaState, &CMsgClientPersonaState{
+		StatusFlags: proto.Uint32(uint32(EClientPersonaStateFlag_PlayerName)),
+		Friends: []*CMsgClientPersonaState_Friend{
+			{Friendid: proto.Uint64(b.ToUint64()), PlayerName: proto.String("b")},
+			{Friendid: proto.Uint64(a.ToUint64()), PlayerName: proto.String("a")},
+		},
+	}))
+	for _, id := range []steamid.SteamId{a, b} {
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+			SteamidFrom:   proto.Uint64(id.ToUint64()),
+			ChatEntryType: proto.Int32(int32(EChatEntryType_ChatMsg)),
+			Message:       []byte("hi"),
+		}))
+	}
+
+	if e := nextEvent(t, client).(*PersonaStateEvent); e.FriendId != a {
+		t.Fatalf("Expected only events of %v, got %+v", a, e)
+	}
+	if e := nextEvent(t, client).(*ChatMsgEvent); steamid.SteamId(e.ChatterId) != a {
+		t.Fatalf("Expected only events of %v, got %+v", a, e)
+	}
+	if len(client.events) != 0 {
+		t.Fatalf("Expected other events to be dropped, got %#v", <-client.events)
+	}
+	if len(subscribed) != 1 || subscribed[0] != a {
+		t.Fatalf("Expected subscribers to be filtered as well, got %v", subscribed)
+	}
+	if friend, _ := client.Social.Friends.ById(b); friend.Name != "b" {
+		t.Fatalf("Expected the cache to be updated for filtered events, got %q", friend.Name)
+	}
+
+	client.Social.SetEventFilter(nil)
+	client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientFriendMsgIncoming, &CMsgClientFriendMsgIncoming{
+		SteamidFrom:   proto.Uint64(b.ToUint64()),
+		ChatEntryType: proto.Int32(int32(EChatEntryType_ChatMsg)),
+		Message:       []byte("hi"),
+	}))
+	if e := nextEvent(t, client).(*ChatMsgEvent); steamid.SteamId(e.ChatterId) != b {
+		t.Fatalf("Expected all events without a filter, got %+v", e)
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	client := newTestClient()
+	friend := steamid.NewIndividual(2)
+	client.Social.EnableSendQueue(2)
+	setConnected := func(connected bool) {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		client.conn = nil
+		if connected {
+			client.conn = &fakeConnection{}
+		}
+	}
+	logOn := func(result EResult) {
+		client.Social.HandlePacket(newProtoPacket(t, EMsg_ClientLogOnResponse, &CMsgClientLogonResponse{
+			Eresult: proto.Int32(int32(result)),
+		}))
+	}
+	sentMessages := func() []string {
+		var messages []string
+		for len(client.writeChan) > 0 {
+			body := new(CMsgClientFriendMsg)
+			toPacket(t, nextWritten(t, client)).ReadProtoMsg(body)
+			messages = append(messages, string(body.GetMessage()))
+		}
+		return messages
+	}
+
+	setConnected(false)
+	for _, message := range []string{"first", "second"} {
+		if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "third"); err != ErrSendQueueFull {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+	client.Social.SetSendQueuePolicy(SendQueueDropOldest)
+	if err := client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "fourth"); err != nil {
+		t.Fatal(err)
+	}
+
+	setConnected(true)
+	logOn(EResult_ServiceUnavailable)
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected queued messages to wait for a successful logon")
+	}
+	// messages sent before the queue is flushed are queued behind it to keep their order
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "fifth")
+	logOn(EResult_OK)
+	if messages := sentMessages(); len(messages) != 2 || messages[0] != "fourth" || messages[1] != "fifth" {
+		t.Fatalf("Expected fourth and fifth to be sent in order, got %v", messages)
+	}
+
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "connected")
+	if messages := sentMessages(); len(messages) != 1 || messages[0] != "connected" {
+		t.Fatalf("Expected messages to be sent right away while logged on, got %v", messages)
+	}
+
+	client.Disconnect()
+	if _, ok := nextEvent(t, client).(*DisconnectedEvent); !ok {
+		t.Fatal("Expected a DisconnectedEvent")
+	}
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "offline")
+	client.mutex.Lock()
+	client.conn = &fakeConnection{}
+	client.writeChan = make(chan IMsg, 64)
+	client.mutex.Unlock()
+	// connected again, but the messages must still wait for the logon
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "handshake")
+	if len(client.writeChan) != 0 {
+		t.Fatal("Expected messages to be queued until logged on")
+	}
+	logOn(EResult_OK)
+	if messages := sentMessages(); len(messages) != 2 || messages[0] != "offline" || messages[1] != "handshake" {
+		t.Fatalf("Expected offline and handshake to be sent in order, got %v", messages)
+	}
+
+	client.Social.EnableSendQueue(0)
+	setConnected(false)
+	client.Social.SendMessage(friend, EChatEntryType_ChatMsg, "lost")
+	setConnected(true)
+	logOn(EResult_OK)
+	if messages := sentMessages(); len(messages) != 0 {
+		t.Fatalf("Expected no queue when disabled, got %v", messages)
+	}
+}
@@ -0,0 +1,159 @@
+package socialcache
+
+import (
+	"errors"
+	"github.com/anovokreschenov/go-steam/steamid"
+	"sync"
+)
+
+// Categories list is a thread safe map of the friend categories ("folders")
+// the user has organized their friends into. They can be iterated over like so:
+//
+//	for id, category := range client.Social.Categories.GetCopy() {
+//		log.Println(id, category.Name, category.Members)
+//	}
+type CategoriesList struct {
+	mutex sync.RWMutex
+	byId  map[int32]*Category
+}
+
+// Returns a new categories list
+func NewCategoriesList() *CategoriesList {
+	return &CategoriesList{byId: make(map[int32]*Category)}
+}
+
+// Adds a category to the categories list. If the category already exists, its name
+// is updated if non-empty and the given members are added to it
+func (list *CategoriesList) Add(category Category) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, exists := list.byId[category.Id]; exists {
+		if category.Name != "" {
+			val.Name = category.Name
+		}
+		for _, member := range category.Members {
+			val.addMember(member)
+		}
+	} else {
+		c := category.copy()
+		list.byId[category.Id] = &c
+	}
+}
+
+// Remove removes a category from the categories list
+func (list *CategoriesList) Remove(id int32) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	delete(list.byId, id)
+}
+
+// Clear removes all categories from the list
+func (list *CategoriesList) Clear() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.byId = make(map[int32]*Category)
+}
+
+// Adds a friend to a category
+func (list *CategoriesList) AddMember(id int32, member steamid.SteamId) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.addMember(member)
+	}
+}
+
+// Removes a friend from a category
+func (list *CategoriesList) RemoveMember(id int32, member steamid.SteamId) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		for i, m := range val.Members {
+			if m == member {
+				val.Members = append(val.Members[:i:i], val.Members[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// GetCopy returns a copy of the categories map
+func (list *CategoriesList) GetCopy() map[int32]Category {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	clist := make(map[int32]Category)
+	for key, category := range list.byId {
+		clist[key] = category.copy()
+	}
+	return clist
+}
+
+// Returns a copy of the category with the given id
+func (list *CategoriesList) ById(id int32) (Category, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return val.copy(), nil
+	}
+	return Category{}, errors.New("Category not found")
+}
+
+// Returns a copy of the friends in the category with the given id
+func (list *CategoriesList) GetMembers(id int32) ([]steamid.SteamId, error) {
+	category, err := list.ById(id)
+	if err != nil {
+		return nil, err
+	}
+	return category.Members, nil
+}
+
+// Returns the categories a friend belongs to
+func (list *CategoriesList) ByMember(member steamid.SteamId) []Category {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	var categories []Category
+	for _, category := range list.byId {
+		if category.hasMember(member) {
+			categories = append(categories, category.copy())
+		}
+	}
+	return categories
+}
+
+// Returns the number of categories
+func (list *CategoriesList) Count() int {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return len(list.byId)
+}
+
+// A friend category
+type Category struct {
+	Id      int32
+	Name    string
+	Members []steamid.SteamId
+}
+
+func (category *Category) hasMember(member steamid.SteamId) bool {
+	for _, m := range category.Members {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
+
+func (category *Category) addMember(member steamid.SteamId) {
+	if !category.hasMember(member) {
+		category.Members = append(category.Members, member)
+	}
+}
+
+// copy returns a copy of the category that doesn't share its members slice
+func (category *Category) copy() Category {
+	c := *category
+	if category.Members != nil {
+		c.Members = append([]steamid.SteamId(nil), category.Members...)
+	}
+	return c
+}
@@ -0,0 +1,40 @@
+package socialcache
+
+import (
+	"testing"
+
+	"github.com/anovokreschenov/go-steam/steamid"
+)
+
+func TestCategoriesMembers(t *testing.T) {
+	list := NewCategoriesList()
+	list.Add(Category{Id: 1, Name: "Friends", Members: []steamid.SteamId{testUserId(1)}})
+	list.AddMember(1, testUserId(2))
+	list.AddMember(1, testUserId(2))
+	list.Add(Category{Id: 2, Name: "Team", Members: []steamid.SteamId{testUserId(2)}})
+
+	members, err := list.GetMembers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0] != testUserId(1) || members[1] != testUserId(2) {
+		t.Fatalf("Unexpected members %v", members)
+	}
+	if categories := list.ByMember(testUserId(2)); len(categories) != 2 {
+		t.Fatalf("Expected friend to be in 2 categories, got %d", len(categories))
+	}
+
+	list.RemoveMember(1, testUserId(1))
+	members, _ = list.GetMembers(1)
+	if len(members) != 1 || members[0] != testUserId(2) {
+		t.Fatalf("Unexpected members after removal %v", members)
+	}
+
+	list.Remove(2)
+	if list.Count() != 1 {
+		t.Fatalf("Expected 1 category, got %d", list.Count())
+	}
+	if _, err := list.ById(2); err == nil {
+		t.Fatal("Expected removed category to be gone")
+	}
+}
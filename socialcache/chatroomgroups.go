@@ -0,0 +1,97 @@
+package socialcache
+
+import (
+	"errors"
+	"github.com/anovokreschenov/go-steam/steamid"
+	"sync"
+)
+
+// Chat room groups list is a thread safe map of the groups of the new chat system
+// They can be iterated over like so:
+//
+//	for id, group := range client.Social.ChatRoomGroups.GetCopy() {
+//		log.Println(id, group.Name)
+//	}
+type ChatRoomGroupsList struct {
+	mutex sync.RWMutex
+	byId  map[steamid.SteamId]*ChatRoomGroup
+}
+
+// Returns a new chat room groups list
+func NewChatRoomGroupsList() *ChatRoomGroupsList {
+	return &ChatRoomGroupsList{byId: make(map[steamid.SteamId]*ChatRoomGroup)}
+}
+
+// Adds a chat room group to the list, replacing an existing group with the same SteamId
+func (list *ChatRoomGroupsList) Add(group ChatRoomGroup) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	group.Channels = append([]ChatRoomChannel(nil), group.Channels...)
+	list.byId[group.SteamId] = &group
+}
+
+// Removes a chat room group from the list
+func (list *ChatRoomGroupsList) Remove(id steamid.SteamId) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	delete(list.byId, id)
+}
+
+// Removes all chat room groups from the list
+func (list *ChatRoomGroupsList) Clear() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.byId = make(map[steamid.SteamId]*ChatRoomGroup)
+}
+
+// Returns a copy of the chat room groups map
+func (list *ChatRoomGroupsList) GetCopy() map[steamid.SteamId]ChatRoomGroup {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	glist := make(map[steamid.SteamId]ChatRoomGroup)
+	for key, group := range list.byId {
+		glist[key] = group.copy()
+	}
+	return glist
+}
+
+// Returns a copy of the chat room group of a given SteamId
+func (list *ChatRoomGroupsList) ById(id steamid.SteamId) (ChatRoomGroup, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return val.copy(), nil
+	}
+	return ChatRoomGroup{}, errors.New("Chat room group not found")
+}
+
+// Returns the number of chat room groups
+func (list *ChatRoomGroupsList) Count() int {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return len(list.byId)
+}
+
+// A group of the new chat system, which holds one or more channels
+type ChatRoomGroup struct {
+	SteamId       steamid.SteamId `json:",string"` // the chat room SteamId used in events and to send messages
+	GroupId       uint64          `json:",string"`
+	ClanId        steamid.SteamId `json:",string"` // zero if the group doesn't belong to a clan
+	Name          string
+	Tagline       string
+	DefaultChatId uint64 `json:",string"`
+	Channels      []ChatRoomChannel
+}
+
+// copy returns a copy of the group that doesn't share its channels slice
+func (group *ChatRoomGroup) copy() ChatRoomGroup {
+	g := *group
+	g.Channels = append([]ChatRoomChannel(nil), group.Channels...)
+	return g
+}
+
+// A channel of a chat room group
+type ChatRoomChannel struct {
+	ChatId uint64 `json:",string"`
+	Name   string
+}
@@ -0,0 +1,32 @@
+package socialcache
+
+import (
+	"testing"
+)
+
+func TestChatRoomGroupsCopies(t *testing.T) {
+	list := NewChatRoomGroupsList()
+	channels := []ChatRoomChannel{{ChatId: 1, Name: "general"}}
+	list.Add(ChatRoomGroup{SteamId: testChatId(1), GroupId: 10, Name: "group", Channels: channels})
+	channels[0].Name = "modified"
+
+	group, err := list.ById(testChatId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "group" || len(group.Channels) != 1 || group.Channels[0].Name != "general" {
+		t.Fatalf("Unexpected group %+v", group)
+	}
+	group.Channels[0].Name = "modified"
+	if copied := list.GetCopy()[testChatId(1)]; copied.Channels[0].Name != "general" {
+		t.Fatal("Expected the returned groups to be copies")
+	}
+	if _, err := list.ById(testChatId(2)); err == nil {
+		t.Fatal("Expected an error for an unknown group")
+	}
+
+	list.Clear()
+	if count := list.Count(); count != 0 {
+		t.Fatalf("Expected no groups after Clear, got %d", count)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
 	"github.com/anovokreschenov/go-steam/steamid"
+	"sort"
 	"sync"
 )
 
@@ -22,12 +23,14 @@ func NewChatsList() *ChatsList {
 	return &ChatsList{byId: make(map[steamid.SteamId]*Chat)}
 }
 
-// Adds a chat to the chat list
+// Adds a chat to the chat list. If the chat already exists, its group id, name
+// and joined state are updated from the non-zero fields of the given chat
 func (list *ChatsList) Add(chat Chat) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	_, exists := list.byId[chat.SteamId]
-	if !exists { //make sure this doesnt already exist
+	if val, exists := list.byId[chat.SteamId]; exists {
+		val.merge(chat)
+	} else {
 		list.byId[chat.SteamId] = &chat
 	}
 }
@@ -39,6 +42,13 @@ func (list *ChatsList) Remove(id steamid.SteamId) {
 	delete(list.byId, id)
 }
 
+// Removes all chats from the chat list
+func (list *ChatsList) Clear() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.byId = make(map[steamid.SteamId]*Chat)
+}
+
 // Adds a chat member to a given chat
 func (list *ChatsList) AddChatMember(id steamid.SteamId, member ChatMember) {
 	list.mutex.Lock()
@@ -54,6 +64,21 @@ func (list *ChatsList) AddChatMember(id steamid.SteamId, member ChatMember) {
 	chat.ChatMembers[member.SteamId] = member
 }
 
+// Replaces all members of a given chat
+func (list *ChatsList) SetChatMembers(id steamid.SteamId, members []ChatMember) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	chat := list.byId[id]
+	if chat == nil { //Chat doesn't exist
+		chat = &Chat{SteamId: id}
+		list.byId[id] = chat
+	}
+	chat.ChatMembers = make(map[steamid.SteamId]ChatMember, len(members))
+	for _, member := range members {
+		chat.ChatMembers[member.SteamId] = member
+	}
+}
+
 // Removes a chat member from a given chat
 func (list *ChatsList) RemoveChatMember(id steamid.SteamId, member steamid.SteamId) {
 	list.mutex.Lock()
@@ -68,13 +93,56 @@ func (list *ChatsList) RemoveChatMember(id steamid.SteamId, member steamid.Steam
 	delete(chat.ChatMembers, member)
 }
 
+// Sets the name of a member in every chat they are in
+func (list *ChatsList) RenameMember(member steamid.SteamId, name string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	for _, chat := range list.byId {
+		if val, ok := chat.ChatMembers[member]; ok {
+			val.Name = name
+			chat.ChatMembers[member] = val
+		}
+	}
+}
+
+// Sets the name of a given chat
+func (list *ChatsList) SetName(id steamid.SteamId, name string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.Name = name
+	}
+}
+
+// Sets whether we have joined a given chat
+func (list *ChatsList) SetJoined(id steamid.SteamId, joined bool) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.Joined = joined
+	}
+}
+
+// Returns copies of all chats we have joined
+func (list *ChatsList) GetJoined() []Chat {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	var chats []Chat
+	for _, chat := range list.byId {
+		if chat.Joined {
+			chats = append(chats, chat.copy())
+		}
+	}
+	return chats
+}
+
 // Returns a copy of the chats map
 func (list *ChatsList) GetCopy() map[steamid.SteamId]Chat {
 	list.mutex.RLock()
 	defer list.mutex.RUnlock()
 	glist := make(map[steamid.SteamId]Chat)
 	for key, chat := range list.byId {
-		glist[key] = *chat
+		glist[key] = chat.copy()
 	}
 	return glist
 }
@@ -84,11 +152,96 @@ func (list *ChatsList) ById(id steamid.SteamId) (Chat, error) {
 	list.mutex.RLock()
 	defer list.mutex.RUnlock()
 	if val, ok := list.byId[id]; ok {
-		return *val, nil
+		return val.copy(), nil
+	}
+	return Chat{}, errors.New("Chat not found")
+}
+
+// Returns a copy of the chat belonging to a given group
+func (list *ChatsList) ByGroupId(groupId steamid.SteamId) (Chat, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	for _, chat := range list.byId {
+		if chat.GroupId == groupId {
+			return chat.copy(), nil
+		}
 	}
 	return Chat{}, errors.New("Chat not found")
 }
 
+// Returns a copy of the members of a given chat
+func (list *ChatsList) GetMembers(id steamid.SteamId) ([]ChatMember, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	chat, ok := list.byId[id]
+	if !ok {
+		return nil, errors.New("Chat not found")
+	}
+	members := make([]ChatMember, 0, len(chat.ChatMembers))
+	for _, member := range chat.ChatMembers {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Returns a copy of the members of a given chat, sorted by role with owners first, then by SteamId
+func (list *ChatsList) GetMembersSorted(id steamid.SteamId) ([]ChatMember, error) {
+	members, err := list.GetMembers(id)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i].Role(), members[j].Role()
+		if a != b {
+			return a < b
+		}
+		return members[i].SteamId < members[j].SteamId
+	})
+	return members, nil
+}
+
+// Returns the chat and clan permissions of a member of a given chat
+func (list *ChatsList) GetMemberPermissions(room, user steamid.SteamId) (EChatPermission, EClanPermission, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	chat, ok := list.byId[room]
+	if !ok {
+		return 0, 0, errors.New("Chat not found")
+	}
+	member, ok := chat.ChatMembers[user]
+	if !ok {
+		return 0, 0, errors.New("Chat member not found")
+	}
+	return member.ChatPermissions, member.ClanPermissions, nil
+}
+
+// Returns the name of a member of a given chat
+func (list *ChatsList) GetMemberName(room, user steamid.SteamId) (string, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	chat, ok := list.byId[room]
+	if !ok {
+		return "", errors.New("Chat not found")
+	}
+	member, ok := chat.ChatMembers[user]
+	if !ok {
+		return "", errors.New("Chat member not found")
+	}
+	return member.Name, nil
+}
+
+// Returns the name of a member from any chat they are in
+func (list *ChatsList) FindMemberName(member steamid.SteamId) (string, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	for _, chat := range list.byId {
+		if val, ok := chat.ChatMembers[member]; ok && val.Name != "" {
+			return val.Name, nil
+		}
+	}
+	return "", errors.New("Chat member name not found")
+}
+
 // Returns the number of chats
 func (list *ChatsList) Count() int {
 	list.mutex.RLock()
@@ -100,12 +253,78 @@ func (list *ChatsList) Count() int {
 type Chat struct {
 	SteamId     steamid.SteamId `json:",string"`
 	GroupId     steamid.SteamId `json:",string"`
+	Name        string
+	Joined      bool // whether we joined the chat rather than only observing it
 	ChatMembers map[steamid.SteamId]ChatMember
 }
 
+// merge updates the group id, name and joined state from the non-zero fields of another chat
+func (chat *Chat) merge(other Chat) {
+	if other.GroupId != 0 {
+		chat.GroupId = other.GroupId
+	}
+	if other.Name != "" {
+		chat.Name = other.Name
+	}
+	if other.Joined {
+		chat.Joined = true
+	}
+}
+
+// copy returns a copy of the chat that doesn't share its members map
+func (chat *Chat) copy() Chat {
+	c := *chat
+	if chat.ChatMembers != nil {
+		c.ChatMembers = make(map[steamid.SteamId]ChatMember, len(chat.ChatMembers))
+		for id, member := range chat.ChatMembers {
+			c.ChatMembers[id] = member
+		}
+	}
+	return c
+}
+
 // A Chat Member
 type ChatMember struct {
 	SteamId         steamid.SteamId `json:",string"`
+	Name            string
 	ChatPermissions EChatPermission
 	ClanPermissions EClanPermission
 }
+
+// Whether the member moderates the chat, either through chat permissions regular members
+// don't have or by being an owner, officer or moderator of the clan. Kick and ban are part
+// of EChatPermission_MemberDefault, so they don't make a member a moderator
+func (member ChatMember) IsModerator() bool {
+	const moderate = EChatPermission_Mute | EChatPermission_ChangePermissions | EChatPermission_ChangeAccess
+	return member.ChatPermissions&moderate != 0 ||
+		member.ClanPermissions&EClanPermission_OwnerOfficerModerator != 0
+}
+
+// Whether the member owns the chat room, or the clan it belongs to
+func (member ChatMember) IsOwner() bool {
+	return member.ChatPermissions&EChatPermission_Close != 0 ||
+		member.ClanPermissions&EClanPermission_Owner != 0
+}
+
+// The role of a chat member, higher roles have lower values
+type ChatMemberRole int
+
+const (
+	ChatMemberRoleOwner ChatMemberRole = iota
+	ChatMemberRoleOfficer
+	ChatMemberRoleModerator
+	ChatMemberRoleMember
+)
+
+// Returns the highest role of the member derived from their chat and clan permissions
+func (member ChatMember) Role() ChatMemberRole {
+	switch {
+	case member.IsOwner():
+		return ChatMemberRoleOwner
+	case member.ClanPermissions&EClanPermission_Officer != 0:
+		return ChatMemberRoleOfficer
+	case member.IsModerator():
+		return ChatMemberRoleModerator
+	}
+	return ChatMemberRoleMember
+}
@@ -0,0 +1,246 @@
+package socialcache
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/steamid"
+)
+
+func testChatId(accountId uint32) steamid.SteamId {
+	return steamid.NewIdAdv(accountId, 0, int32(EUniverse_Public), EAccountType_Chat)
+}
+
+func testUserId(accountId uint32) steamid.SteamId {
+	return steamid.NewIdAdv(accountId, 1, int32(EUniverse_Public), EAccountType_Individual)
+}
+
+// TestGetMembers tests reading members while they are concurrently added, run with -race
+func TestGetMembers(t *testing.T) {
+	list := NewChatsList()
+	chatId := testChatId(1)
+	list.Add(Chat{SteamId: chatId})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint32(0); i < 100; i++ {
+			list.AddChatMember(chatId, ChatMember{SteamId: testUserId(i + 1)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		members, err := list.GetMembers(chatId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, member := range members {
+			_ = member.SteamId
+		}
+	}
+	wg.Wait()
+
+	members, err := list.GetMembers(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 100 {
+		t.Fatalf("Expected 100 members, got %d", len(members))
+	}
+	if _, err := list.GetMembers(testChatId(2)); err == nil {
+		t.Fatal("Expected an error for an unknown chat")
+	}
+}
+
+// TestGetCopyIsolated tests iterating a copy while members are concurrently added, run with -race
+func TestGetCopyIsolated(t *testing.T) {
+	list := NewChatsList()
+	chatId := testChatId(1)
+	list.AddChatMember(chatId, ChatMember{SteamId: testUserId(1)})
+
+	chats := list.GetCopy()
+	chat, err := list.ById(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint32(0); i < 100; i++ {
+			list.AddChatMember(chatId, ChatMember{SteamId: testUserId(i + 2)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		for id := range chats[chatId].ChatMembers {
+			_ = id
+		}
+		for id := range chat.ChatMembers {
+			_ = id
+		}
+	}
+	wg.Wait()
+
+	if len(chats[chatId].ChatMembers) != 1 || len(chat.ChatMembers) != 1 {
+		t.Fatal("Expected copies to be unaffected by later additions")
+	}
+}
+
+func TestByGroupId(t *testing.T) {
+	list := NewChatsList()
+	groupId := steamid.NewIdAdv(5, 0, int32(EUniverse_Public), EAccountType_Clan)
+	list.Add(Chat{SteamId: testChatId(1)})
+	list.Add(Chat{SteamId: groupId.ClanToChat(), GroupId: groupId})
+
+	chat, err := list.ByGroupId(groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.SteamId != groupId.ClanToChat() {
+		t.Fatalf("Expected chat %v, got %v", groupId.ClanToChat(), chat.SteamId)
+	}
+
+	otherId := steamid.NewIdAdv(6, 0, int32(EUniverse_Public), EAccountType_Clan)
+	if _, err := list.ByGroupId(otherId); err == nil {
+		t.Fatal("Expected an error for an unknown group")
+	}
+}
+
+func TestGetJoined(t *testing.T) {
+	list := NewChatsList()
+	list.Add(Chat{SteamId: testChatId(1), Joined: true})
+	list.Add(Chat{SteamId: testChatId(2)})
+	list.Add(Chat{SteamId: testChatId(3), Joined: true})
+	list.SetJoined(testChatId(3), false)
+
+	joined := list.GetJoined()
+	if len(joined) != 1 || joined[0].SteamId != testChatId(1) {
+		t.Fatalf("Expected only %v to be joined, got %v", testChatId(1), joined)
+	}
+}
+
+func TestGetMemberPermissions(t *testing.T) {
+	list := NewChatsList()
+	list.Add(Chat{SteamId: testChatId(1)})
+	list.AddChatMember(testChatId(1), ChatMember{
+		SteamId:         testUserId(1),
+		ChatPermissions: EChatPermission_Talk | EChatPermission_Invite,
+		ClanPermissions: EClanPermission_Member,
+	})
+	list.AddChatMember(testChatId(1), ChatMember{
+		SteamId:         testUserId(2),
+		ChatPermissions: EChatPermission_Talk | EChatPermission_Kick | EChatPermission_Ban | EChatPermission_Mute,
+		ClanPermissions: EClanPermission_Member,
+	})
+	list.AddChatMember(testChatId(1), ChatMember{
+		SteamId:         testUserId(3),
+		ChatPermissions: EChatPermission_OwnerDefault,
+		ClanPermissions: EClanPermission_Owner,
+	})
+	list.AddChatMember(testChatId(1), ChatMember{
+		SteamId:         testUserId(5),
+		ChatPermissions: EChatPermission_MemberDefault,
+		ClanPermissions: EClanPermission_Member,
+	})
+
+	chatPerm, clanPerm, err := list.GetMemberPermissions(testChatId(1), testUserId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chatPerm != EChatPermission_Talk|EChatPermission_Invite || clanPerm != EClanPermission_Member {
+		t.Fatalf("Unexpected permissions %v, %v", chatPerm, clanPerm)
+	}
+
+	members, _ := list.GetMembers(testChatId(1))
+	for _, member := range members {
+		moderator := member.SteamId == testUserId(2) || member.SteamId == testUserId(3)
+		owner := member.SteamId == testUserId(3)
+		if member.IsModerator() != moderator || member.IsOwner() != owner {
+			t.Fatalf("%v: expected moderator %v and owner %v", member.SteamId, moderator, owner)
+		}
+	}
+
+	if _, _, err := list.GetMemberPermissions(testChatId(1), testUserId(4)); err == nil {
+		t.Fatal("Expected an error for an unknown member")
+	}
+	if _, _, err := list.GetMemberPermissions(testChatId(2), testUserId(1)); err == nil {
+		t.Fatal("Expected an error for an unknown chat")
+	}
+}
+
+func TestRenameMember(t *testing.T) {
+	list := NewChatsList()
+	list.AddChatMember(testChatId(1), ChatMember{SteamId: testUserId(1), Name: "old"})
+	list.AddChatMember(testChatId(2), ChatMember{SteamId: testUserId(1), Name: "old"})
+	list.AddChatMember(testChatId(2), ChatMember{SteamId: testUserId(2), Name: "other"})
+
+	list.RenameMember(testUserId(1), "new")
+	for _, room := range []steamid.SteamId{testChatId(1), testChatId(2)} {
+		if name, err := list.GetMemberName(room, testUserId(1)); err != nil || name != "new" {
+			t.Fatalf("%v: expected name new, got %q, %v", room, name, err)
+		}
+	}
+	if name, _ := list.GetMemberName(testChatId(2), testUserId(2)); name != "other" {
+		t.Fatalf("Expected other members to keep their name, got %q", name)
+	}
+	if _, err := list.GetMemberName(testChatId(1), testUserId(2)); err == nil {
+		t.Fatal("Expected an error for an unknown member")
+	}
+	if name, err := list.FindMemberName(testUserId(2)); err != nil || name != "other" {
+		t.Fatalf("Expected to find other in any chat, got %q, %v", name, err)
+	}
+	if _, err := list.FindMemberName(testUserId(3)); err == nil {
+		t.Fatal("Expected an error for a member of no chat")
+	}
+}
+
+func TestGetMembersSorted(t *testing.T) {
+	list := NewChatsList()
+	members := []ChatMember{
+		{SteamId: testUserId(1), ChatPermissions: EChatPermission_Talk, ClanPermissions: EClanPermission_Member},
+		{SteamId: testUserId(2), ClanPermissions: EClanPermission_Moderator},
+		{SteamId: testUserId(3), ChatPermissions: EChatPermission_Talk},
+		{SteamId: testUserId(4), ClanPermissions: EClanPermission_Officer},
+		{SteamId: testUserId(5), ChatPermissions: EChatPermission_Kick | EChatPermission_Ban | EChatPermission_Mute},
+		{SteamId: testUserId(6), ClanPermissions: EClanPermission_Owner},
+		{SteamId: testUserId(7), ChatPermissions: EChatPermission_MemberDefault, ClanPermissions: EClanPermission_Member},
+	}
+	for _, member := range members {
+		list.AddChatMember(testChatId(1), member)
+	}
+
+	sorted, err := list.GetMembersSorted(testChatId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []steamid.SteamId{testUserId(6), testUserId(4), testUserId(2), testUserId(5), testUserId(1), testUserId(3), testUserId(7)}
+	if len(sorted) != len(expected) {
+		t.Fatalf("Expected %d members, got %d", len(expected), len(sorted))
+	}
+	for i, member := range sorted {
+		if member.SteamId != expected[i] {
+			t.Fatalf("Expected %v at position %d, got %v", expected[i], i, member.SteamId)
+		}
+	}
+	if _, err := list.GetMembersSorted(testChatId(2)); err == nil {
+		t.Fatal("Expected an error for an unknown chat")
+	}
+}
+
+func TestChatClear(t *testing.T) {
+	list := NewChatsList()
+	list.Add(Chat{SteamId: testChatId(1)})
+	list.AddChatMember(testChatId(2), ChatMember{SteamId: testUserId(1)})
+	snapshot := list.GetCopy()
+	list.Clear()
+	if count := list.Count(); count != 0 {
+		t.Fatalf("Expected no chats after Clear, got %d", count)
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("Expected the earlier copy to keep 2 chats, got %d", len(snapshot))
+	}
+	if len(snapshot[testChatId(2)].ChatMembers) != 1 {
+		t.Fatal("Expected the earlier copy to keep its members")
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"errors"
 	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
 	"github.com/anovokreschenov/go-steam/steamid"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // FriendsList is a thread safe map
@@ -22,13 +26,16 @@ func NewFriendsList() *FriendsList {
 	return &FriendsList{byId: make(map[steamid.SteamId]*Friend)}
 }
 
-// Add adds a friend to the friend list
+// Add adds a friend to the friend list. If the friend already exists, its relationship
+// and all non-zero fields of the given friend are updated
 func (list *FriendsList) Add(friend Friend) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	_, exists := list.byId[steamid.SteamId(friend.SteamId)]
-	if !exists { //make sure this doesnt already exist
-		list.byId[steamid.SteamId(friend.SteamId)] = &friend
+	if val, exists := list.byId[friend.SteamId]; exists {
+		val.merge(friend)
+	} else {
+		friend.RichPresence = copyRichPresence(friend.RichPresence)
+		list.byId[friend.SteamId] = &friend
 	}
 }
 
@@ -39,6 +46,13 @@ func (list *FriendsList) Remove(id steamid.SteamId) {
 	delete(list.byId, id)
 }
 
+// Removes all friends from the friend list
+func (list *FriendsList) Clear() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.byId = make(map[steamid.SteamId]*Friend)
+}
+
 // Returns a copy of the friends map
 func (list *FriendsList) GetCopy() map[steamid.SteamId]Friend {
 	list.mutex.RLock()
@@ -60,6 +74,74 @@ func (list *FriendsList) ById(id steamid.SteamId) (Friend, error) {
 	return Friend{}, errors.New("Friend not found")
 }
 
+// GetPendingIncoming returns copies of all friends who sent us a friend request
+func (list *FriendsList) GetPendingIncoming() []Friend {
+	return list.byRelationship(EFriendRelationship_RequestRecipient)
+}
+
+// GetPendingOutgoing returns copies of all friends we sent a friend request to
+func (list *FriendsList) GetPendingOutgoing() []Friend {
+	return list.byRelationship(EFriendRelationship_RequestInitiator)
+}
+
+func (list *FriendsList) byRelationship(relationship EFriendRelationship) []Friend {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	var friends []Friend
+	for _, friend := range list.byId {
+		if friend.Relationship == relationship {
+			friends = append(friends, *friend)
+		}
+	}
+	return friends
+}
+
+// Search returns copies of all friends whose name or nickname contains the query,
+// ignoring case, sorted by name
+func (list *FriendsList) Search(query string) []Friend {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	query = strings.ToLower(query)
+	var friends []Friend
+	for _, friend := range list.byId {
+		if strings.Contains(strings.ToLower(friend.Name), query) ||
+			strings.Contains(strings.ToLower(friend.Nickname), query) {
+			friends = append(friends, *friend)
+		}
+	}
+	sort.Slice(friends, func(i, j int) bool {
+		a, b := strings.ToLower(friends[i].Name), strings.ToLower(friends[j].Name)
+		if a != b {
+			return a < b
+		}
+		return friends[i].SteamId < friends[j].SteamId
+	})
+	return friends
+}
+
+// Diff compares a snapshot returned by GetCopy with the current friends. It returns the
+// ids of added and removed friends and copies of the friends whose fields have changed,
+// each sorted by SteamId
+func (list *FriendsList) Diff(old map[steamid.SteamId]Friend) (added, removed []steamid.SteamId, changed []Friend) {
+	current := list.GetCopy()
+	for id, friend := range current {
+		if oldFriend, ok := old[id]; !ok {
+			added = append(added, id)
+		} else if !reflect.DeepEqual(oldFriend, friend) {
+			changed = append(changed, friend)
+		}
+	}
+	for id := range old {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	sort.Slice(changed, func(i, j int) bool { return changed[i].SteamId < changed[j].SteamId })
+	return added, removed, changed
+}
+
 // Returns the number of friends
 func (list *FriendsList) Count() int {
 	list.mutex.RLock()
@@ -67,6 +149,87 @@ func (list *FriendsList) Count() int {
 	return len(list.byId)
 }
 
+// CountByState returns the number of friends with the given persona state
+func (list *FriendsList) CountByState(state EPersonaState) int {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	count := 0
+	for _, friend := range list.byId {
+		if friend.PersonaState == state {
+			count++
+		}
+	}
+	return count
+}
+
+// OnlineCount returns the number of friends with any persona state other than Offline
+func (list *FriendsList) OnlineCount() int {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	count := 0
+	for _, friend := range list.byId {
+		if friend.PersonaState != EPersonaState_Offline {
+			count++
+		}
+	}
+	return count
+}
+
+// GetPersonaStateFlags returns the persona state flags of a given friend
+func (list *FriendsList) GetPersonaStateFlags(id steamid.SteamId) (EPersonaStateFlag, bool) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return val.PersonaStateFlags, true
+	}
+	return 0, false
+}
+
+// GetSourceSteamId returns the SteamId through which we know a given friend, e.g. a shared group
+func (list *FriendsList) GetSourceSteamId(id steamid.SteamId) (steamid.SteamId, bool) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return val.SourceSteamId, true
+	}
+	return 0, false
+}
+
+// GetRichPresence returns a copy of the rich presence key/values of a given friend
+func (list *FriendsList) GetRichPresence(id steamid.SteamId) (map[string]string, bool) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return copyRichPresence(val.RichPresence), true
+	}
+	return nil, false
+}
+
+// LastSeen returns when a given friend last logged on and off
+func (list *FriendsList) LastSeen(id steamid.SteamId) (lastLogOn, lastLogOff time.Time, err error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	if val, ok := list.byId[id]; ok {
+		return val.LastLogOn, val.LastLogOff, nil
+	}
+	return time.Time{}, time.Time{}, errors.New("Friend not found")
+}
+
+// ClearPresence resets the persona state, game and rich presence of every friend,
+// e.g. when the cached presence may be stale after a reconnect
+func (list *FriendsList) ClearPresence() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	for _, friend := range list.byId {
+		friend.PersonaState = EPersonaState_Offline
+		friend.PersonaStateFlags = 0
+		friend.GameAppId = 0
+		friend.GameId = 0
+		friend.GameName = ""
+		friend.RichPresence = nil
+	}
+}
+
 //Setter methods
 func (list *FriendsList) SetName(id steamid.SteamId, name string) {
 	list.mutex.Lock()
@@ -76,6 +239,14 @@ func (list *FriendsList) SetName(id steamid.SteamId, name string) {
 	}
 }
 
+func (list *FriendsList) SetNickname(id steamid.SteamId, nickname string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.Nickname = nickname
+	}
+}
+
 func (list *FriendsList) SetAvatar(id steamid.SteamId, hash string) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
@@ -132,10 +303,44 @@ func (list *FriendsList) SetGameName(id steamid.SteamId, name string) {
 	}
 }
 
+func (list *FriendsList) SetLastLogOn(id steamid.SteamId, logOn time.Time) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.LastLogOn = logOn
+	}
+}
+
+func (list *FriendsList) SetLastLogOff(id steamid.SteamId, logOff time.Time) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.LastLogOff = logOff
+	}
+}
+
+func (list *FriendsList) SetSourceSteamId(id steamid.SteamId, source steamid.SteamId) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.SourceSteamId = source
+	}
+}
+
+// SetRichPresence replaces the rich presence of a friend with a copy of the given map
+func (list *FriendsList) SetRichPresence(id steamid.SteamId, richPresence map[string]string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if val, ok := list.byId[id]; ok {
+		val.RichPresence = copyRichPresence(richPresence)
+	}
+}
+
 // A Friend
 type Friend struct {
 	SteamId           steamid.SteamId `json:",string"`
 	Name              string
+	Nickname          string // the nickname given to the friend by the local user
 	Avatar            string
 	Relationship      EFriendRelationship
 	PersonaState      EPersonaState
@@ -143,4 +348,60 @@ type Friend struct {
 	GameAppId         uint32
 	GameId            uint64 `json:",string"`
 	GameName          string
+	LastLogOn         time.Time
+	LastLogOff        time.Time
+	SourceSteamId     steamid.SteamId   `json:",string"` // how we know the friend, e.g. a shared group
+	RichPresence      map[string]string // replaced on update, never modified in place
+}
+
+// merge updates the relationship and all non-zero fields from another friend
+func (f *Friend) merge(other Friend) {
+	f.Relationship = other.Relationship
+	if other.Name != "" {
+		f.Name = other.Name
+	}
+	if other.Nickname != "" {
+		f.Nickname = other.Nickname
+	}
+	if other.Avatar != "" {
+		f.Avatar = other.Avatar
+	}
+	if other.PersonaState != 0 {
+		f.PersonaState = other.PersonaState
+	}
+	if other.PersonaStateFlags != 0 {
+		f.PersonaStateFlags = other.PersonaStateFlags
+	}
+	if other.GameAppId != 0 {
+		f.GameAppId = other.GameAppId
+	}
+	if other.GameId != 0 {
+		f.GameId = other.GameId
+	}
+	if other.GameName != "" {
+		f.GameName = other.GameName
+	}
+	if !other.LastLogOn.IsZero() {
+		f.LastLogOn = other.LastLogOn
+	}
+	if !other.LastLogOff.IsZero() {
+		f.LastLogOff = other.LastLogOff
+	}
+	if other.SourceSteamId != 0 {
+		f.SourceSteamId = other.SourceSteamId
+	}
+	if other.RichPresence != nil {
+		f.RichPresence = copyRichPresence(other.RichPresence)
+	}
+}
+
+func copyRichPresence(richPresence map[string]string) map[string]string {
+	if richPresence == nil {
+		return nil
+	}
+	c := make(map[string]string, len(richPresence))
+	for key, value := range richPresence {
+		c[key] = value
+	}
+	return c
 }
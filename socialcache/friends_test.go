@@ -0,0 +1,223 @@
+package socialcache
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/steamid"
+)
+
+func TestPendingRequests(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Relationship: EFriendRelationship_Friend})
+	list.Add(Friend{SteamId: testUserId(2), Relationship: EFriendRelationship_RequestRecipient})
+	list.Add(Friend{SteamId: testUserId(3), Relationship: EFriendRelationship_RequestInitiator})
+	list.Add(Friend{SteamId: testUserId(4), Relationship: EFriendRelationship_RequestRecipient})
+	list.Add(Friend{SteamId: testUserId(5), Relationship: EFriendRelationship_Blocked})
+
+	incoming := list.GetPendingIncoming()
+	if len(incoming) != 2 {
+		t.Fatalf("Expected 2 incoming requests, got %d", len(incoming))
+	}
+	for _, friend := range incoming {
+		if friend.SteamId != testUserId(2) && friend.SteamId != testUserId(4) {
+			t.Fatalf("Unexpected incoming request from %v", friend.SteamId)
+		}
+	}
+	outgoing := list.GetPendingOutgoing()
+	if len(outgoing) != 1 || outgoing[0].SteamId != testUserId(3) {
+		t.Fatalf("Expected one outgoing request to %v, got %v", testUserId(3), outgoing)
+	}
+}
+
+func TestLastSeen(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Relationship: EFriendRelationship_Friend})
+	logOn := time.Unix(1500000000, 0)
+	logOff := time.Unix(1500003600, 0)
+	list.SetLastLogOn(testUserId(1), logOn)
+	list.SetLastLogOff(testUserId(1), logOff)
+
+	gotOn, gotOff, err := list.LastSeen(testUserId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotOn.Equal(logOn) || !gotOff.Equal(logOff) {
+		t.Fatalf("Expected %v and %v, got %v and %v", logOn, logOff, gotOn, gotOff)
+	}
+	if _, _, err := list.LastSeen(testUserId(2)); err == nil {
+		t.Fatal("Expected an error for an unknown friend")
+	}
+}
+
+func TestAddUpdatesExisting(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Name: "name", Avatar: "avatar", Relationship: EFriendRelationship_RequestRecipient})
+	list.Add(Friend{SteamId: testUserId(1), Relationship: EFriendRelationship_Friend})
+
+	friend, err := list.ById(testUserId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if friend.Relationship != EFriendRelationship_Friend {
+		t.Fatalf("Expected relationship Friend, got %v", friend.Relationship)
+	}
+	if friend.Name != "name" || friend.Avatar != "avatar" {
+		t.Fatalf("Expected name and avatar to be kept, got %q and %q", friend.Name, friend.Avatar)
+	}
+	if list.Count() != 1 {
+		t.Fatalf("Expected 1 friend, got %d", list.Count())
+	}
+}
+
+func TestRichPresence(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Relationship: EFriendRelationship_Friend})
+	richPresence := map[string]string{"steam_display": "#Status"}
+	list.SetRichPresence(testUserId(1), richPresence)
+	richPresence["steam_display"] = "modified"
+
+	got, ok := list.GetRichPresence(testUserId(1))
+	if !ok || got["steam_display"] != "#Status" {
+		t.Fatalf("Expected steam_display #Status, got %v", got)
+	}
+	got["steam_display"] = "modified"
+	if again, _ := list.GetRichPresence(testUserId(1)); again["steam_display"] != "#Status" {
+		t.Fatal("Expected the returned map to be a copy")
+	}
+	if _, ok := list.GetRichPresence(testUserId(2)); ok {
+		t.Fatal("Expected no rich presence for an unknown friend")
+	}
+}
+
+func TestPersonaStateFlags(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Relationship: EFriendRelationship_Friend})
+	list.SetPersonaStateFlags(testUserId(1), EPersonaStateFlag_OnlineUsingMobile)
+
+	flags, ok := list.GetPersonaStateFlags(testUserId(1))
+	if !ok || flags != EPersonaStateFlag_OnlineUsingMobile {
+		t.Fatalf("Expected %v, got %v", EPersonaStateFlag_OnlineUsingMobile, flags)
+	}
+	if _, ok := list.GetPersonaStateFlags(testUserId(2)); ok {
+		t.Fatal("Expected no flags for an unknown friend")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Name: "gaben"})
+	list.Add(Friend{SteamId: testUserId(2), Name: "Robin Walker"})
+	list.Add(Friend{SteamId: testUserId(3), Name: "xX_sniper_Xx", Nickname: "Robert"})
+	list.Add(Friend{SteamId: testUserId(4), Name: "Alice"})
+
+	results := list.Search("ROB")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+	if results[0].SteamId != testUserId(2) || results[1].SteamId != testUserId(3) {
+		t.Fatalf("Expected results sorted by name, got %v", results)
+	}
+
+	list.SetNickname(testUserId(4), "Gabe's friend")
+	results = list.Search("gab")
+	if len(results) != 2 || results[0].Name != "Alice" || results[1].Name != "gaben" {
+		t.Fatalf("Expected nickname and name matches sorted by name, got %v", results)
+	}
+	if results := list.Search("nobody"); len(results) != 0 {
+		t.Fatalf("Expected no results, got %v", results)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), Name: "unchanged"})
+	list.Add(Friend{SteamId: testUserId(2), Name: "removed"})
+	list.Add(Friend{SteamId: testUserId(3), Name: "renamed"})
+	list.Add(Friend{SteamId: testUserId(4), Name: "playing"})
+	list.SetRichPresence(testUserId(4), map[string]string{"status": "menu"})
+	snapshot := list.GetCopy()
+
+	list.Remove(testUserId(2))
+	list.SetName(testUserId(3), "new name")
+	list.SetRichPresence(testUserId(4), map[string]string{"status": "in game"})
+	list.Add(Friend{SteamId: testUserId(6), Name: "added"})
+	list.Add(Friend{SteamId: testUserId(5), Name: "added"})
+
+	added, removed, changed := list.Diff(snapshot)
+	if len(added) != 2 || added[0] != testUserId(5) || added[1] != testUserId(6) {
+		t.Fatalf("Unexpected added %v", added)
+	}
+	if len(removed) != 1 || removed[0] != testUserId(2) {
+		t.Fatalf("Unexpected removed %v", removed)
+	}
+	if len(changed) != 2 || changed[0].Name != "new name" || changed[1].RichPresence["status"] != "in game" {
+		t.Fatalf("Unexpected changed %v", changed)
+	}
+
+	added, removed, changed = list.Diff(list.GetCopy())
+	if len(added) != 0 || len(removed) != 0 || len(changed) != 0 {
+		t.Fatalf("Expected no differences, got %v, %v, %v", added, removed, changed)
+	}
+}
+
+func TestCountByState(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1), PersonaState: EPersonaState_Online})
+	list.Add(Friend{SteamId: testUserId(2), PersonaState: EPersonaState_Away})
+	list.Add(Friend{SteamId: testUserId(3), PersonaState: EPersonaState_Offline})
+	list.Add(Friend{SteamId: testUserId(4), PersonaState: EPersonaState_Online})
+	list.Add(Friend{SteamId: testUserId(5)})
+
+	if count := list.CountByState(EPersonaState_Online); count != 2 {
+		t.Fatalf("Expected 2 online friends, got %d", count)
+	}
+	if count := list.CountByState(EPersonaState_Offline); count != 2 {
+		t.Fatalf("Expected 2 offline friends, got %d", count)
+	}
+	if count := list.CountByState(EPersonaState_Busy); count != 0 {
+		t.Fatalf("Expected no busy friends, got %d", count)
+	}
+	if count := list.OnlineCount(); count != 3 {
+		t.Fatalf("Expected 3 friends not offline, got %d", count)
+	}
+}
+
+func TestSourceSteamId(t *testing.T) {
+	list := NewFriendsList()
+	group := steamid.NewClan(4)
+	list.Add(Friend{SteamId: testUserId(1)})
+	if source, ok := list.GetSourceSteamId(testUserId(1)); !ok || source != 0 {
+		t.Fatalf("Expected no source, got %v, %v", source, ok)
+	}
+	list.SetSourceSteamId(testUserId(1), group)
+	if source, ok := list.GetSourceSteamId(testUserId(1)); !ok || source != group {
+		t.Fatalf("Expected source %v, got %v, %v", group, source, ok)
+	}
+	list.Add(Friend{SteamId: testUserId(1), Name: "renamed"})
+	if source, _ := list.GetSourceSteamId(testUserId(1)); source != group {
+		t.Fatalf("Expected the source to be kept on update, got %v", source)
+	}
+	if _, ok := list.GetSourceSteamId(testUserId(2)); ok {
+		t.Fatal("Expected no source for an unknown friend")
+	}
+}
+
+func TestClear(t *testing.T) {
+	list := NewFriendsList()
+	list.Add(Friend{SteamId: testUserId(1)})
+	list.Add(Friend{SteamId: testUserId(2)})
+	snapshot := list.GetCopy()
+	list.Clear()
+	if count := list.Count(); count != 0 {
+		t.Fatalf("Expected no friends after Clear, got %d", count)
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("Expected the earlier copy to keep 2 friends, got %d", len(snapshot))
+	}
+	list.Add(Friend{SteamId: testUserId(3)})
+	if count := list.Count(); count != 1 {
+		t.Fatalf("Expected the list to be usable after Clear, got %d friends", count)
+	}
+}
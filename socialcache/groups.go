@@ -15,19 +15,29 @@ import (
 type GroupsList struct {
 	mutex sync.RWMutex
 	byId  map[steamid.SteamId]*Group
+
+	callbackMutex  sync.RWMutex
+	nextCallbackId int
+	countCallbacks map[int]func(id steamid.SteamId, online uint32)
 }
 
 // Returns a new groups list
 func NewGroupsList() *GroupsList {
-	return &GroupsList{byId: make(map[steamid.SteamId]*Group)}
+	return &GroupsList{
+		byId:           make(map[steamid.SteamId]*Group),
+		countCallbacks: make(map[int]func(steamid.SteamId, uint32)),
+	}
 }
 
-// Adds a group to the group list
+// Adds a group to the group list. If the group already exists, its relationship
+// and all non-zero fields of the given group are updated
 func (list *GroupsList) Add(group Group) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	_, exists := list.byId[group.SteamId]
-	if !exists { //make sure this doesnt already exist
+	group.SteamId = group.SteamId.ChatToClan()
+	if val, exists := list.byId[group.SteamId]; exists {
+		val.merge(group)
+	} else {
 		list.byId[group.SteamId] = &group
 	}
 }
@@ -36,9 +46,17 @@ func (list *GroupsList) Add(group Group) {
 func (list *GroupsList) Remove(id steamid.SteamId) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
+	id = id.ChatToClan()
 	delete(list.byId, id)
 }
 
+// Removes all groups from the group list
+func (list *GroupsList) Clear() {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.byId = make(map[steamid.SteamId]*Group)
+}
+
 // GetCopy returns a copy of the groups map
 func (list *GroupsList) GetCopy() map[steamid.SteamId]Group {
 	list.mutex.RLock()
@@ -50,11 +68,12 @@ func (list *GroupsList) GetCopy() map[steamid.SteamId]Group {
 	return glist
 }
 
-// Returns a copy of the group of a given SteamId
+// Returns a copy of the group of a given SteamId. The SteamId of the group's
+// chat room may be used as well
 func (list *GroupsList) ById(id steamid.SteamId) (Group, error) {
 	list.mutex.RLock()
 	defer list.mutex.RUnlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
 	if val, ok := list.byId[id]; ok {
 		return *val, nil
 	}
@@ -68,11 +87,62 @@ func (list *GroupsList) Count() int {
 	return len(list.byId)
 }
 
+// Returns the sum of the member counts of all groups
+func (list *GroupsList) TotalMembers() uint64 {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	var total uint64
+	for _, group := range list.byId {
+		total += uint64(group.MemberTotalCount)
+	}
+	return total
+}
+
+// Returns the sum of the online member counts of all groups
+func (list *GroupsList) TotalOnlineMembers() uint64 {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	var total uint64
+	for _, group := range list.byId {
+		total += uint64(group.MemberOnlineCount)
+	}
+	return total
+}
+
+// OnMemberCountChange registers a callback invoked when the total, online or in-game
+// member count of a group changes. It receives the group's current online count.
+// Returns a function that unregisters the callback
+func (list *GroupsList) OnMemberCountChange(callback func(id steamid.SteamId, online uint32)) func() {
+	list.callbackMutex.Lock()
+	defer list.callbackMutex.Unlock()
+	callbackId := list.nextCallbackId
+	list.nextCallbackId++
+	list.countCallbacks[callbackId] = callback
+	return func() {
+		list.callbackMutex.Lock()
+		defer list.callbackMutex.Unlock()
+		delete(list.countCallbacks, callbackId)
+	}
+}
+
+// memberCountChanged invokes the member count callbacks, it must be called without holding the lock
+func (list *GroupsList) memberCountChanged(id steamid.SteamId, online uint32) {
+	list.callbackMutex.RLock()
+	callbacks := make([]func(steamid.SteamId, uint32), 0, len(list.countCallbacks))
+	for _, callback := range list.countCallbacks {
+		callbacks = append(callbacks, callback)
+	}
+	list.callbackMutex.RUnlock()
+	for _, callback := range callbacks {
+		callback(id, online)
+	}
+}
+
 //Setter methods
 func (list *GroupsList) SetName(id steamid.SteamId, name string) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
 	if val, ok := list.byId[id]; ok {
 		val.Name = name
 	}
@@ -81,16 +151,25 @@ func (list *GroupsList) SetName(id steamid.SteamId, name string) {
 func (list *GroupsList) SetAvatar(id steamid.SteamId, hash string) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
 	if val, ok := list.byId[id]; ok {
 		val.Avatar = hash
 	}
 }
 
+func (list *GroupsList) SetClanTag(id steamid.SteamId, tag string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	id = id.ChatToClan()
+	if val, ok := list.byId[id]; ok {
+		val.ClanTag = tag
+	}
+}
+
 func (list *GroupsList) SetRelationship(id steamid.SteamId, relationship EClanRelationship) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
 	if val, ok := list.byId[id]; ok {
 		val.Relationship = relationship
 	}
@@ -98,26 +177,40 @@ func (list *GroupsList) SetRelationship(id steamid.SteamId, relationship EClanRe
 
 func (list *GroupsList) SetMemberTotalCount(id steamid.SteamId, count uint32) {
 	list.mutex.Lock()
-	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
+	changed := false
+	var online uint32
 	if val, ok := list.byId[id]; ok {
+		changed = val.MemberTotalCount != count
 		val.MemberTotalCount = count
+		online = val.MemberOnlineCount
+	}
+	list.mutex.Unlock()
+	if changed {
+		list.memberCountChanged(id, online)
 	}
 }
 
 func (list *GroupsList) SetMemberOnlineCount(id steamid.SteamId, count uint32) {
 	list.mutex.Lock()
-	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
+	changed := false
+	var online uint32
 	if val, ok := list.byId[id]; ok {
+		changed = val.MemberOnlineCount != count
 		val.MemberOnlineCount = count
+		online = val.MemberOnlineCount
+	}
+	list.mutex.Unlock()
+	if changed {
+		list.memberCountChanged(id, online)
 	}
 }
 
 func (list *GroupsList) SetMemberChattingCount(id steamid.SteamId, count uint32) {
 	list.mutex.Lock()
 	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
 	if val, ok := list.byId[id]; ok {
 		val.MemberChattingCount = count
 	}
@@ -125,10 +218,36 @@ func (list *GroupsList) SetMemberChattingCount(id steamid.SteamId, count uint32)
 
 func (list *GroupsList) SetMemberInGameCount(id steamid.SteamId, count uint32) {
 	list.mutex.Lock()
-	defer list.mutex.Unlock()
-	// id = id.ChatToClan()
+	id = id.ChatToClan()
+	changed := false
+	var online uint32
 	if val, ok := list.byId[id]; ok {
+		changed = val.MemberInGameCount != count
 		val.MemberInGameCount = count
+		online = val.MemberOnlineCount
+	}
+	list.mutex.Unlock()
+	if changed {
+		list.memberCountChanged(id, online)
+	}
+}
+
+// SetMemberCounts sets all member counts of a group at once, so the member count
+// callbacks are invoked at most once
+func (list *GroupsList) SetMemberCounts(id steamid.SteamId, total, online, chatting, inGame uint32) {
+	list.mutex.Lock()
+	id = id.ChatToClan()
+	changed := false
+	if val, ok := list.byId[id]; ok {
+		changed = val.MemberTotalCount != total || val.MemberOnlineCount != online || val.MemberInGameCount != inGame
+		val.MemberTotalCount = total
+		val.MemberOnlineCount = online
+		val.MemberChattingCount = chatting
+		val.MemberInGameCount = inGame
+	}
+	list.mutex.Unlock()
+	if changed {
+		list.memberCountChanged(id, online)
 	}
 }
 
@@ -137,9 +256,36 @@ type Group struct {
 	SteamId             steamid.SteamId `json:",string"`
 	Name                string
 	Avatar              string
+	ClanTag             string
 	Relationship        EClanRelationship
 	MemberTotalCount    uint32
 	MemberOnlineCount   uint32
 	MemberChattingCount uint32
 	MemberInGameCount   uint32
 }
+
+// merge updates the relationship and all non-zero fields from another group
+func (g *Group) merge(other Group) {
+	g.Relationship = other.Relationship
+	if other.Name != "" {
+		g.Name = other.Name
+	}
+	if other.Avatar != "" {
+		g.Avatar = other.Avatar
+	}
+	if other.ClanTag != "" {
+		g.ClanTag = other.ClanTag
+	}
+	if other.MemberTotalCount != 0 {
+		g.MemberTotalCount = other.MemberTotalCount
+	}
+	if other.MemberOnlineCount != 0 {
+		g.MemberOnlineCount = other.MemberOnlineCount
+	}
+	if other.MemberChattingCount != 0 {
+		g.MemberChattingCount = other.MemberChattingCount
+	}
+	if other.MemberInGameCount != 0 {
+		g.MemberInGameCount = other.MemberInGameCount
+	}
+}
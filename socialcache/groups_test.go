@@ -0,0 +1,151 @@
+package socialcache
+
+import (
+	"testing"
+
+	. "github.com/anovokreschenov/go-steam/protocol/steamlang"
+	"github.com/anovokreschenov/go-steam/steamid"
+)
+
+func testGroupId(accountId uint32) steamid.SteamId {
+	return steamid.NewIdAdv(accountId, 0, int32(EUniverse_Public), EAccountType_Clan)
+}
+
+func TestGroupAddUpdatesExisting(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1), Name: "group", Relationship: EClanRelationship_Invited})
+	list.Add(Group{SteamId: testGroupId(1), Relationship: EClanRelationship_Member})
+
+	group, err := list.ById(testGroupId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Relationship != EClanRelationship_Member {
+		t.Fatalf("Expected relationship Member, got %v", group.Relationship)
+	}
+	if group.Name != "group" {
+		t.Fatalf("Expected name to be kept, got %q", group.Name)
+	}
+}
+
+func TestSetClanTag(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1), Relationship: EClanRelationship_Member})
+	list.SetClanTag(testGroupId(1), "TAG")
+
+	group, err := list.ById(testGroupId(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.ClanTag != "TAG" {
+		t.Fatalf("Expected clan tag TAG, got %q", group.ClanTag)
+	}
+}
+
+func TestGroupMemberTotals(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1), MemberTotalCount: 100, MemberOnlineCount: 10})
+	list.Add(Group{SteamId: testGroupId(2), MemberTotalCount: 4000000000, MemberOnlineCount: 5})
+	list.Add(Group{SteamId: testGroupId(3), MemberTotalCount: 4000000000})
+
+	if total := list.TotalMembers(); total != 8000000100 {
+		t.Fatalf("Expected 8000000100 members, got %d", total)
+	}
+	if online := list.TotalOnlineMembers(); online != 15 {
+		t.Fatalf("Expected 15 online members, got %d", online)
+	}
+}
+
+func TestGroupByChatId(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1), Name: "group"})
+
+	for _, id := range []steamid.SteamId{testGroupId(1), testGroupId(1).ClanToChat()} {
+		group, err := list.ById(id)
+		if err != nil {
+			t.Fatalf("%v: %v", id, err)
+		}
+		if group.SteamId != testGroupId(1) {
+			t.Fatalf("%v: expected group %v, got %v", id, testGroupId(1), group.SteamId)
+		}
+	}
+
+	list.SetName(testGroupId(1).ClanToChat(), "renamed")
+	if group, _ := list.ById(testGroupId(1)); group.Name != "renamed" {
+		t.Fatalf("Expected setters to accept the chat id, got name %q", group.Name)
+	}
+	if _, err := list.ById(steamid.NewIdAdv(1, 0, int32(EUniverse_Public), EAccountType_Chat)); err == nil {
+		t.Fatal("Expected an ad-hoc chat not to match the group")
+	}
+}
+
+func TestOnMemberCountChange(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1), MemberOnlineCount: 5})
+
+	var calls []uint32
+	unsubscribe := list.OnMemberCountChange(func(id steamid.SteamId, online uint32) {
+		if id != testGroupId(1) {
+			t.Errorf("Expected %v, got %v", testGroupId(1), id)
+		}
+		// the lock must not be held while callbacks run
+		list.ById(id)
+		calls = append(calls, online)
+	})
+
+	list.SetMemberOnlineCount(testGroupId(1), 5)
+	if len(calls) != 0 {
+		t.Fatalf("Expected no callback for an unchanged count, got %v", calls)
+	}
+	list.SetMemberOnlineCount(testGroupId(1), 6)
+	list.SetMemberTotalCount(testGroupId(1), 100)
+	list.SetMemberTotalCount(testGroupId(1), 100)
+	list.SetMemberInGameCount(testGroupId(1), 2)
+	list.SetMemberOnlineCount(testGroupId(2), 1)
+	if len(calls) != 3 || calls[0] != 6 || calls[1] != 6 || calls[2] != 6 {
+		t.Fatalf("Expected 3 callbacks with 6 online, got %v", calls)
+	}
+
+	unsubscribe()
+	list.SetMemberOnlineCount(testGroupId(1), 7)
+	if len(calls) != 3 {
+		t.Fatalf("Expected no callbacks after unsubscribing, got %v", calls)
+	}
+}
+
+func TestSetMemberCounts(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1)})
+
+	var calls []uint32
+	list.OnMemberCountChange(func(id steamid.SteamId, online uint32) {
+		calls = append(calls, online)
+	})
+
+	list.SetMemberCounts(testGroupId(1), 100, 6, 3, 2)
+	if len(calls) != 1 || calls[0] != 6 {
+		t.Fatalf("Expected a single callback with 6 online, got %v", calls)
+	}
+	group, _ := list.ById(testGroupId(1))
+	if group.MemberTotalCount != 100 || group.MemberOnlineCount != 6 || group.MemberChattingCount != 3 || group.MemberInGameCount != 2 {
+		t.Fatalf("Unexpected member counts %+v", group)
+	}
+	list.SetMemberCounts(testGroupId(1), 100, 6, 4, 2)
+	if len(calls) != 1 {
+		t.Fatalf("Expected no callback when only the chatting count changes, got %v", calls)
+	}
+}
+
+func TestGroupClear(t *testing.T) {
+	list := NewGroupsList()
+	list.Add(Group{SteamId: testGroupId(1)})
+	list.Add(Group{SteamId: testGroupId(2)})
+	snapshot := list.GetCopy()
+	list.Clear()
+	if count := list.Count(); count != 0 {
+		t.Fatalf("Expected no groups after Clear, got %d", count)
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("Expected the earlier copy to keep 2 groups, got %d", len(snapshot))
+	}
+}
@@ -13,7 +13,7 @@ import (
 type SteamId uint64
 
 func fromAccountId(id uint32) SteamId {
-	return NewIdAdv(id, 1, 1, 1)
+	return NewIndividual(id)
 }
 
 func fromAccountIdStr(id string) (SteamId, error) {
@@ -68,6 +68,22 @@ func NewId(id string) (SteamId, error) {
 	return SteamId(newid), nil
 }
 
+// NewIndividual returns the SteamId of a user in the public universe with the desktop instance.
+func NewIndividual(accountId uint32) SteamId {
+	return NewIdAdv(accountId, DesktopInstance, int32(steamlang.EUniverse_Public), steamlang.EAccountType_Individual)
+}
+
+// NewClan returns the SteamId of a clan in the public universe.
+func NewClan(accountId uint32) SteamId {
+	return NewIdAdv(accountId, 0, int32(steamlang.EUniverse_Public), steamlang.EAccountType_Clan)
+}
+
+// NewChat returns the SteamId of a chat room in the public universe.
+// Use ClanToChat to get the chat room of a clan.
+func NewChat(accountId uint32) SteamId {
+	return NewIdAdv(accountId, 0, int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+}
+
 func NewIdAdv(accountId, instance uint32, universe int32, accountType steamlang.EAccountType) SteamId {
 	s := SteamId(0)
 	s = s.SetAccountId(accountId)
@@ -100,6 +116,29 @@ func (s SteamId) String() string {
 	}
 }
 
+// MarshalJSON encodes the SteamId as a quoted decimal string, because the 64-bit value
+// can't be represented exactly by JavaScript numbers.
+func (s SteamId) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.ToString())), nil
+}
+
+// UnmarshalJSON decodes a SteamId from a quoted or unquoted decimal number.
+func (s *SteamId) UnmarshalJSON(data []byte) error {
+	str := string(data)
+	if str == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(str); err == nil {
+		str = unquoted
+	}
+	id, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return fmt.Errorf("steamid: cannot unmarshal %s into a SteamId", data)
+	}
+	*s = SteamId(id)
+	return nil
+}
+
 func (s SteamId) get(offset uint, mask uint64) uint64 {
 	return (uint64(s) >> offset) & mask
 }
@@ -144,24 +183,105 @@ func (s SteamId) SetAccountUniverse(universe int32) SteamId {
 	return s.set(56, 0xF, uint64(universe))
 }
 
-// used to fix the Clan SteamId to a Chat SteamId
+// AccountId returns the account number, the lower 32 bits of the SteamId.
+// It is the same as GetAccountId.
+func (s SteamId) AccountId() uint32 {
+	return s.GetAccountId()
+}
+
+// Instance returns the account instance.
+func (s SteamId) Instance() uint32 {
+	return uint32(s.GetAccountInstance())
+}
+
+// Universe returns the universe the account belongs to.
+func (s SteamId) Universe() steamlang.EUniverse {
+	return steamlang.EUniverse(s.GetAccountUniverse())
+}
+
+// IsValid reports whether the SteamId is plausible: it must have a known universe and account type,
+// and individual, clan and game server ids must have an account id and a matching instance.
+func (s SteamId) IsValid() bool {
+	if s == 0 {
+		return false
+	}
+	universe := steamlang.EUniverse(s.GetAccountUniverse())
+	if universe <= steamlang.EUniverse_Invalid || universe >= steamlang.EUniverse_Max {
+		return false
+	}
+	switch s.GetAccountType() {
+	case steamlang.EAccountType_Invalid:
+		return false
+	case steamlang.EAccountType_Individual:
+		return s.GetAccountId() != 0 && uint32(s.GetAccountInstance()) <= WebInstance
+	case steamlang.EAccountType_Clan:
+		return s.GetAccountId() != 0 && s.GetAccountInstance() == 0
+	case steamlang.EAccountType_GameServer:
+		return s.GetAccountId() != 0
+	}
+	return true
+}
+
+// IsIndividual reports whether the SteamId belongs to a user.
+func (s SteamId) IsIndividual() bool {
+	return s.GetAccountType() == steamlang.EAccountType_Individual
+}
+
+// IsClan reports whether the SteamId belongs to a clan (group).
+func (s SteamId) IsClan() bool {
+	return s.GetAccountType() == steamlang.EAccountType_Clan
+}
+
+// IsChat reports whether the SteamId belongs to a chat room.
+func (s SteamId) IsChat() bool {
+	return s.GetAccountType() == steamlang.EAccountType_Chat
+}
+
+// IsGameServer reports whether the SteamId belongs to a persistent or anonymous game server.
+func (s SteamId) IsGameServer() bool {
+	accType := s.GetAccountType()
+	return accType == steamlang.EAccountType_GameServer || accType == steamlang.EAccountType_AnonGameServer
+}
+
+// ClanToChat returns the SteamId of a clan's chat room. A clan's chat room has the
+// clan's account id with the Chat account type and ChatInstanceFlagClan as its instance.
+// Any other SteamId is returned unchanged.
 func (s SteamId) ClanToChat() SteamId {
-	if s.GetAccountType() == steamlang.EAccountType(7) { //EAccountType_Clan
+	if s.GetAccountType() == steamlang.EAccountType_Clan {
 		s = s.SetAccountInstance(uint32(ChatInstanceFlagClan))
-		s = s.SetAccountType(steamlang.EAccountType_Chat) //EAccountType_Chat
+		s = s.SetAccountType(steamlang.EAccountType_Chat)
 	}
 	return s
 }
 
-//used to fix the Chat SteamId to a Clan SteamId
+// ChatToClan returns the clan a clan chat room belongs to, reversing ClanToChat.
+// Chats without ChatInstanceFlagClan, like ad-hoc multi-user chats, don't belong to a
+// clan and are returned unchanged, as is any other SteamId.
 func (s SteamId) ChatToClan() SteamId {
-	if s.GetAccountType() == steamlang.EAccountType_Chat { //EAccountType_Chat
-		s = s.SetAccountInstance(0)
-		s = s.SetAccountType(steamlang.EAccountType_Clan) //EAccountType_Clan
+	if s.GetAccountType() == steamlang.EAccountType_Chat && s.GetAccountInstance().HasFlag(uint32(ChatInstanceFlagClan)) {
+		s = s.SetAccountInstance(uint32(s.GetAccountInstance()) &^ uint32(ChatInstanceFlagClan))
+		s = s.SetAccountType(steamlang.EAccountType_Clan)
 	}
 	return s
 }
 
+// IsChatClan reports whether the SteamId is the chat room of a clan rather than an ad-hoc
+// multi-user chat or a lobby.
+func (s SteamId) IsChatClan() bool {
+	return s.IsChat() && s.GetAccountInstance().HasFlag(uint32(ChatInstanceFlagClan))
+}
+
+// IsZero reports whether the SteamId is the zero value, which is used for unset ids.
+func (s SteamId) IsZero() bool {
+	return s == 0
+}
+
+// Equal reports whether two SteamIds refer to the same account. A clan and its chat room
+// are considered equal, so either form may be compared against the other.
+func (s SteamId) Equal(other SteamId) bool {
+	return s.ChatToClan() == other.ChatToClan()
+}
+
 // ToSteam2 converts to the steam2 ID representation.
 func (s SteamId) ToSteam2() string {
 	return s.String()
@@ -1,7 +1,10 @@
 package steamid
 
 import (
+	"encoding/json"
 	"testing"
+
+	"github.com/anovokreschenov/go-steam/protocol/steamlang"
 )
 
 // TestSteamID3 tests a steamid3 format
@@ -39,3 +42,213 @@ func TestSteamID32(t *testing.T) {
 		t.Fatalf("%d != 76561198029304414", id.ToUint64())
 	}
 }
+
+// TestIsValid tests the validity and account type predicates
+func TestIsValid(t *testing.T) {
+	public := int32(steamlang.EUniverse_Public)
+	tests := []struct {
+		name       string
+		id         SteamId
+		valid      bool
+		individual bool
+		clan       bool
+		chat       bool
+		gameServer bool
+	}{
+		{"individual", NewIdAdv(69038686, DesktopInstance, public, steamlang.EAccountType_Individual), true, true, false, false, false},
+		{"clan", NewIdAdv(4, 0, public, steamlang.EAccountType_Clan), true, false, true, false, false},
+		{"chat", NewIdAdv(4, uint32(ChatInstanceFlagClan), public, steamlang.EAccountType_Chat), true, false, false, true, false},
+		{"game server", NewIdAdv(5, 1, public, steamlang.EAccountType_GameServer), true, false, false, false, true},
+		{"anon game server", NewIdAdv(0, 7, public, steamlang.EAccountType_AnonGameServer), true, false, false, false, true},
+		{"zero", SteamId(0), false, false, false, false, false},
+		{"invalid universe", NewIdAdv(69038686, DesktopInstance, 0, steamlang.EAccountType_Individual), false, true, false, false, false},
+		{"invalid type", NewIdAdv(69038686, DesktopInstance, public, steamlang.EAccountType_Invalid), false, false, false, false, false},
+		{"individual without account", NewIdAdv(0, DesktopInstance, public, steamlang.EAccountType_Individual), false, true, false, false, false},
+		{"clan with instance", NewIdAdv(4, 1, public, steamlang.EAccountType_Clan), false, false, true, false, false},
+	}
+	for _, test := range tests {
+		if test.id.IsValid() != test.valid {
+			t.Errorf("%s: IsValid() = %v, expected %v", test.name, !test.valid, test.valid)
+		}
+		if test.id.IsIndividual() != test.individual || test.id.IsClan() != test.clan ||
+			test.id.IsChat() != test.chat || test.id.IsGameServer() != test.gameServer {
+			t.Errorf("%s: unexpected account type predicates for %v", test.name, test.id.GetAccountType())
+		}
+	}
+}
+
+// TestNewFromAccountId tests the account id constructors
+func TestNewFromAccountId(t *testing.T) {
+	if id := NewIndividual(69038686); id.ToUint64() != uint64(76561198029304414) {
+		t.Fatalf("%d != 76561198029304414", id.ToUint64())
+	}
+	if id := NewClan(4); id.ToUint64() != uint64(103582791429521412) {
+		t.Fatalf("%d != 103582791429521412", id.ToUint64())
+	}
+	if id := NewChat(4); id.ToUint64() != uint64(108086391056891908) {
+		t.Fatalf("%d != 108086391056891908", id.ToUint64())
+	}
+	if NewClan(4).ClanToChat() == NewChat(4) {
+		t.Fatal("Expected a clan chat to have the clan instance flag")
+	}
+}
+
+// TestJSON tests JSON round-trips of SteamIds
+func TestJSON(t *testing.T) {
+	id := SteamId(76561198029304414)
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"76561198029304414"` {
+		t.Fatalf(`%s != "76561198029304414"`, data)
+	}
+	var decoded SteamId
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != id {
+		t.Fatalf("%d != %d (%v)", decoded, id, err)
+	}
+
+	ids := []SteamId{id, NewClan(4)}
+	data, err = json.Marshal(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedIds []SteamId
+	if err := json.Unmarshal(data, &decodedIds); err != nil || len(decodedIds) != 2 || decodedIds[1] != NewClan(4) {
+		t.Fatalf("%v != %v (%v)", decodedIds, ids, err)
+	}
+
+	type nested struct {
+		Id       SteamId
+		Tagged   SteamId `json:",string"`
+		Pointers []*SteamId
+	}
+	value := nested{Id: id, Tagged: id, Pointers: []*SteamId{&id}}
+	data, err = json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"Id":"76561198029304414","Tagged":"76561198029304414","Pointers":["76561198029304414"]}` {
+		t.Fatalf("Unexpected encoding %s", data)
+	}
+	var decodedValue nested
+	if err := json.Unmarshal(data, &decodedValue); err != nil {
+		t.Fatal(err)
+	}
+	if decodedValue.Id != id || decodedValue.Tagged != id || *decodedValue.Pointers[0] != id {
+		t.Fatalf("%+v != %+v", decodedValue, value)
+	}
+
+	if err := json.Unmarshal([]byte(`76561198029304414`), &decoded); err != nil || decoded != id {
+		t.Fatalf("Expected unquoted numbers to decode, got %d (%v)", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`"STEAM_0:0:1"`), &decoded); err == nil {
+		t.Fatal("Expected an error for a non-numeric SteamId")
+	}
+}
+
+// TestClanChatConversion tests converting between clans and their chat rooms
+func TestClanChatConversion(t *testing.T) {
+	clanChat := func(accountId uint32) SteamId {
+		return NewIdAdv(accountId, uint32(ChatInstanceFlagClan), int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+	}
+	lobby := NewIdAdv(5, uint32(ChatInstanceFlagLobby), int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+	tests := []struct {
+		id   SteamId
+		chat SteamId
+		clan SteamId
+	}{
+		{NewClan(4), clanChat(4), NewClan(4)},
+		{NewClan(103582791), clanChat(103582791), NewClan(103582791)},
+		{clanChat(4), clanChat(4), NewClan(4)},
+		{NewChat(4), NewChat(4), NewChat(4)}, // ad-hoc multi-user chat
+		{lobby, lobby, lobby},
+		{NewIndividual(4), NewIndividual(4), NewIndividual(4)},
+	}
+	for _, test := range tests {
+		if chat := test.id.ClanToChat(); chat != test.chat {
+			t.Errorf("%v.ClanToChat() = %v, expected %v", test.id.ToSteam3(), chat.ToSteam3(), test.chat.ToSteam3())
+		}
+		if clan := test.id.ChatToClan(); clan != test.clan {
+			t.Errorf("%v.ChatToClan() = %v, expected %v", test.id.ToSteam3(), clan.ToSteam3(), test.clan.ToSteam3())
+		}
+		if roundTrip := test.id.ClanToChat().ChatToClan().ClanToChat(); roundTrip != test.chat {
+			t.Errorf("%v doesn't round-trip, got %v", test.id.ToSteam3(), roundTrip.ToSteam3())
+		}
+	}
+	if NewClan(4).ClanToChat().ToSteam3() != "[c:1:4]" {
+		t.Errorf("Expected a clan chat, got %v", NewClan(4).ClanToChat().ToSteam3())
+	}
+}
+
+// TestAccessors tests the account id, instance and universe accessors
+func TestAccessors(t *testing.T) {
+	tests := []struct {
+		id        SteamId
+		accountId uint32
+		instance  uint32
+		universe  steamlang.EUniverse
+	}{
+		{SteamId(76561197960287930), 22202, DesktopInstance, steamlang.EUniverse_Public},
+		{SteamId(76561198029304414), 69038686, DesktopInstance, steamlang.EUniverse_Public},
+		{NewClan(103582791), 103582791, 0, steamlang.EUniverse_Public},
+		{NewClan(4).ClanToChat(), 4, uint32(ChatInstanceFlagClan), steamlang.EUniverse_Public},
+		{NewIdAdv(5, WebInstance, int32(steamlang.EUniverse_Beta), steamlang.EAccountType_Individual), 5, WebInstance, steamlang.EUniverse_Beta},
+	}
+	for _, test := range tests {
+		if test.id.AccountId() != test.accountId || test.id.Instance() != test.instance || test.id.Universe() != test.universe {
+			t.Errorf("%d: got account id %d, instance %d and universe %v, expected %d, %d and %v", test.id,
+				test.id.AccountId(), test.id.Instance(), test.id.Universe(), test.accountId, test.instance, test.universe)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !SteamId(0).IsZero() || NewIndividual(1).IsZero() {
+		t.Fatal("IsZero should only be true for the zero SteamId")
+	}
+	lobby := NewIdAdv(4, uint32(ChatInstanceFlagLobby), int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+	tests := []struct {
+		a, b  SteamId
+		equal bool
+	}{
+		{NewIndividual(4), NewIndividual(4), true},
+		{NewIndividual(4), NewIndividual(5), false},
+		{NewClan(4), NewClan(4).ClanToChat(), true},
+		{NewClan(4).ClanToChat(), NewClan(4), true},
+		{NewClan(4), NewClan(5).ClanToChat(), false},
+		{NewClan(4), NewChat(4), false},
+		{NewClan(4), lobby, false},
+		{NewIndividual(4), NewClan(4), false},
+	}
+	for _, test := range tests {
+		if test.a.Equal(test.b) != test.equal {
+			t.Errorf("%v.Equal(%v) != %v", test.a.ToSteam3(), test.b.ToSteam3(), test.equal)
+		}
+	}
+}
+
+// TestChatForms tests telling clan chats apart from ad-hoc multi-user chats and lobbies
+func TestChatForms(t *testing.T) {
+	clanChat := NewIdAdv(4, uint32(ChatInstanceFlagClan), int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+	lobby := NewIdAdv(4, uint32(ChatInstanceFlagLobby), int32(steamlang.EUniverse_Public), steamlang.EAccountType_Chat)
+	tests := []struct {
+		id       SteamId
+		chatClan bool
+	}{
+		{NewClan(4), false},
+		{clanChat, true},
+		{NewChat(4), false},
+		{lobby, false},
+		{NewIndividual(4), false},
+	}
+	for _, test := range tests {
+		if test.id.IsChatClan() != test.chatClan {
+			t.Errorf("%v.IsChatClan() != %v", test.id.ToSteam3(), test.chatClan)
+		}
+	}
+	if NewClan(4).ClanToChat() != clanChat || clanChat.ChatToClan() != NewClan(4) || NewChat(4).ChatToClan() != NewChat(4) {
+		t.Error("Expected ClanToChat and ChatToClan to convert only clan chats")
+	}
+}